@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Marks a POST as a tar of several files, extracted by the server relative to
+// the directory it was posted to
+const batchHeader = "X-Fetch2pi-Batch"
+
+// Flush a batch once it holds this much, so batches stay cheap to hold in
+// memory and to retry
+const maxBatchBytes = 64 << 20
+
+// A small file waiting to go out in a batch
+type batchEntry struct {
+	name   string
+	URL    string
+	data   []byte
+	header http.Header
+	start  time.Time
+}
+
+// Below relays the files of one directory listing, tarring those up to the
+// -relay-batch size into as few uploads as possible. Each is sized with a HEAD
+// first, anything larger, or whose size isn't known, is handed to proxyFile
+// as normal. The rest get the same size limits, health checks and breaker a
+// single relay would.
+func relayBatch(dlURL, dirPath, dest string, hrefs []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	health.wait()
+	releaseDownload := downloadSlots.acquire()
+	defer releaseDownload()
+
+	var batch []batchEntry
+	var size int
+	for _, href := range hrefs {
+		URL := dlURL + href
+		fileSize, err := headSize(URL)
+		if err != nil || fileSize < 0 || fileSize > conf.batchThreshold {
+			wg.Add(1)
+			go proxyFile(URL, dirPath+href, dest, wg)
+			continue
+		}
+		if !sizeInLimits(fileSize) {
+			dbg.Println("Outside the size limits, skipping: ", dirPath+href)
+			results.add(fileResult{Path: dirPath + href, Status: statusSkipped})
+			summary.skip()
+			continue
+		}
+
+		entry, ok := fetchSmall(URL, fileSize)
+		if !ok {
+			wg.Add(1)
+			go proxyFile(URL, dirPath+href, dest, wg)
+			continue
+		}
+
+		entry.name = href
+		if eolWanted(href) {
+			entry.data, _ = ioutil.ReadAll(newEOLReader(bytes.NewReader(entry.data)))
+		}
+		batch = append(batch, entry)
+		size += len(entry.data)
+		if size >= maxBatchBytes {
+			sendBatch(dirPath, dest, batch)
+			batch, size = nil, 0
+		}
+	}
+	if len(batch) > 0 {
+		sendBatch(dirPath, dest, batch)
+	}
+}
+
+// Download a file HEAD said was size bytes, false means relay it alone
+func fetchSmall(URL string, size int64) (batchEntry, bool) {
+	release := hosts.acquire(URL)
+	defer release()
+
+	entry := batchEntry{URL: URL, start: time.Now()}
+	if simulateFailure() {
+		return entry, false
+	}
+	req, err := http.NewRequestWithContext(runCtx, "GET", URL, nil)
+	if err != nil {
+		return entry, false
+	}
+	resp, err := sourceFetcher.fetch(req)
+	if err != nil {
+		return entry, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return entry, false
+	}
+
+	// Anything other than what HEAD promised changed in between, so it's
+	// left to a relay of its own
+	entry.data, err = ioutil.ReadAll(io.LimitReader(resp.Body, size+1))
+	if err != nil || int64(len(entry.data)) != size {
+		return entry, false
+	}
+	entry.header = resp.Header
+	return entry, true
+}
+
+func sendBatch(dirPath, dest string, batch []batchEntry) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range batch {
+		modTime := time.Now()
+		if lm, err := http.ParseTime(entry.header.Get("Last-Modified")); err == nil {
+			modTime = lm
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(entry.data)),
+			ModTime:  modTime,
+			Typeflag: tar.TypeReg,
+		})
+		if err == nil {
+			_, err = tw.Write(entry.data)
+		}
+		if err != nil {
+			failBatch(dirPath, batch, 0, err)
+			return
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		failBatch(dirPath, batch, 0, err)
+		return
+	}
+
+	relayURL := dest + cleanSlashes(dirPath)
+	for i := 1; ; i++ {
+		breaker.wait()
+		err = postBatch(relayURL, buf.Bytes())
+		breaker.record(err)
+		if err == nil {
+			break
+		}
+		er.Println(err, ", RETRY COUNT: ", i, ", FOR BATCH: ", relayURL)
+		if isPermanent(err) || interrupted() || i == maxRetries || !budget.take() {
+			failBatch(dirPath, batch, i-1, err)
+			return
+		}
+		time.Sleep(retryDelay(i, err))
+	}
+
+	dbg.Printf("Relayed a batch of %d files to %s", len(batch), relayURL)
+	for _, entry := range batch {
+		path := dirPath + entry.name
+		results.add(fileResult{
+			Path:    path,
+			Bytes:   int64(len(entry.data)),
+			Seconds: time.Since(entry.start).Seconds(),
+			Status:  statusOK,
+		})
+		finishFile(entry.URL, path, entry.header, manifestEntry{
+			Size:     int64(len(entry.data)),
+			Checksum: checksumOf(entry.data),
+		})
+	}
+}
+
+func postBatch(relayURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(runCtx, conf.relayMethod, relayURL, bytes.NewReader(body))
+	if err != nil {
+		return permanent(newTransferError(ErrRelay, relayURL, err))
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	req.Header.Set(batchHeader, "tar")
+	// Nothing in the batch is stored unless the whole tar arrived intact
+	h := checksum.new()
+	h.Write(body)
+	req.Header.Set("Digest", checksum.token+"="+base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	if simulateFailure() {
+		return newTransferError(ErrRelay, relayURL, errSimulated)
+	}
+
+	done := sinkGate.acquire()
+	resp, err := relayClient.Do(req)
+	if err != nil {
+		done(true)
+		return newTransferError(ErrRelay, relayURL, err)
+	}
+	done(overloaded(resp))
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	status := fmt.Errorf("server responded %s", resp.Status)
+	switch {
+	case overloaded(resp):
+		return newTransferError(ErrRelay, relayURL, &statusError{
+			status:     resp.Status,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		})
+	case resp.StatusCode >= 400:
+		return permanent(newTransferError(ErrRelay, relayURL, status))
+	}
+	return nil
+}
+
+func failBatch(dirPath string, batch []batchEntry, retries int, err error) {
+	for _, entry := range batch {
+		results.add(fileResult{
+			Path:    dirPath + entry.name,
+			Seconds: time.Since(entry.start).Seconds(),
+			Retries: retries,
+			Status:  statusFailed,
+			Error:   err.Error(),
+		})
+		summary.fail(err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Below stops a run grinding through attempts that are all going to fail once
+// something systemic breaks. When more than threshold of the last window
+// attempts failed, new attempts wait out the cool-down, or with -fail-fast the
+// run is wound down like an interrupt and exits with the failure that tripped
+// it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold float64
+	window    int
+	cooldown  time.Duration
+	failFast  bool
+
+	outcomes  []bool
+	next      int
+	openUntil time.Time
+	tripped   error
+}
+
+var breaker = circuitBreaker{window: 20, cooldown: time.Minute}
+
+// Note the outcome of one transfer attempt, a failure tripping the breaker if
+// too many have failed
+func (b *circuitBreaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.outcomes) < b.window {
+		b.outcomes = append(b.outcomes, err != nil)
+	} else {
+		b.outcomes[b.next] = err != nil
+		b.next = (b.next + 1) % b.window
+	}
+	if err == nil || len(b.outcomes) < b.window {
+		return
+	}
+
+	failed := 0
+	for _, f := range b.outcomes {
+		if f {
+			failed++
+		}
+	}
+	rate := float64(failed) / float64(b.window)
+	if rate <= b.threshold {
+		return
+	}
+
+	if b.failFast {
+		if b.tripped == nil {
+			b.tripped = fmt.Errorf("%.0f%% of the last %d attempts failed, giving up: %w", rate*100, b.window, err)
+			er.Println(b.tripped, ", aborting transfers in progress")
+			cancelRun()
+		}
+		return
+	}
+	er.Printf("%.0f%% of the last %d attempts failed, pausing for %s", rate*100, b.window, b.cooldown)
+	b.openUntil = time.Now().Add(b.cooldown)
+	// Judge things afresh once the cool-down is over
+	b.outcomes, b.next = b.outcomes[:0], 0
+}
+
+// Block while the breaker is open, or until the run is interrupted
+func (b *circuitBreaker) wait() {
+	for !interrupted() {
+		b.mu.Lock()
+		remaining := time.Until(b.openUntil)
+		b.mu.Unlock()
+		if remaining <= 0 {
+			return
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// What tripped the breaker with -fail-fast, nil unless it has
+func (b *circuitBreaker) err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tripped
+}
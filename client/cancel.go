@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Cancelled by the first SIGINT or SIGTERM, aborting transfers in flight so
+// the server sees them cut short and discards what it had. Results and state
+// are still written out, a second signal exits straight away.
+var runCtx, cancelRun = context.WithCancel(context.Background())
+
+func cancelOnSignal() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		er.Println("Interrupted, aborting transfers in progress")
+		cancelRun()
+		<-sigs
+		os.Exit(exitCode(ErrInterrupted))
+	}()
+}
+
+func interrupted() bool {
+	return runCtx.Err() != nil
+}
+
+// Set once -max-runtime is reached, which winds the run down just like an
+// interrupt but exits saying so
+var deadlineHit int32
+
+func cancelAfter(d time.Duration) {
+	time.AfterFunc(d, func() {
+		er.Println("Reached -max-runtime of ", d, ", aborting transfers in progress")
+		atomic.StoreInt32(&deadlineHit, 1)
+		cancelRun()
+	})
+}
+
+// Why the run was cut short, once it has been
+func stopReason() error {
+	if err := breaker.err(); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&deadlineHit) == 1 {
+		return ErrDeadlineExceeded
+	}
+	return ErrInterrupted
+}
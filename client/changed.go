@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Below backs -list-changed, a dry run that only reports which files a mirror
+// update would transfer. Each file is HEADed for its size and, with
+// -sums-file, given its published checksum, then the lot is diffed against
+// the old manifest like -diff would.
+func listChange(URL, path string) {
+	size, err := headSize(URL)
+	if err != nil {
+		er.Println("Error checking source file: ", err)
+		summary.fail(newTransferError(ErrSourceUnreachable, URL, err))
+		return
+	}
+	// An unknown size of -1 never matches, so it's reported as changed
+	current.add(path, manifestEntry{Size: size, Checksum: published.get(URL)})
+}
+
+func printChanged() {
+	d := diffManifests(previous, current)
+	for _, path := range d.Added {
+		fmt.Println("new", path)
+	}
+	for _, path := range d.Changed {
+		fmt.Println("changed", path)
+	}
+	dbg.Printf("%d new and %d changed files", len(d.Added), len(d.Changed))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// A hash the client can verify relays with, token is its RFC 3230 name
+type checksumAlgo struct {
+	name  string
+	token string
+	new   func() hash.Hash
+}
+
+var checksumAlgos = map[string]checksumAlgo{
+	"sha256": {"sha256", "SHA-256", sha256.New},
+	"sha1":   {"sha1", "SHA", sha1.New},
+	"md5":    {"md5", "MD5", md5.New},
+}
+
+// Chosen with -checksum-algo
+var checksum = checksumAlgos["sha256"]
+
+// Below hashes the relay body as it streams and, once it's all been read, sets
+// a Digest trailer on the request so the server can check what it stored
+type digestReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	req    *http.Request
+	// Of the whole body when known, -1 otherwise
+	length int64
+	// A published checksum the body should match, sent up front as its
+	// Digest so the server refuses a mismatch instead of storing it
+	published string
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{reader: r, hash: checksum.new(), length: -1}
+}
+
+func (d *digestReader) Read(p []byte) (n int, err error) {
+	n, err = d.reader.Read(p)
+	d.hash.Write(p[:n])
+	if err == io.EOF && d.req != nil {
+		d.req.Trailer.Set("Digest", checksum.token+"="+base64.StdEncoding.EncodeToString(d.hash.Sum(nil)))
+	}
+	return
+}
+
+// The Digest to send before the body, from its published checksum when
+// that's in the algorithm relays are checked with
+func (d *digestReader) expected() string {
+	parts := strings.SplitN(d.published, ":", 2)
+	if len(parts) != 2 || parts[0] != checksum.name {
+		return ""
+	}
+	raw, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	return checksum.token + "=" + base64.StdEncoding.EncodeToString(raw)
+}
+
+// The finished checksum in the algo:hex form manifests use
+func (d *digestReader) String() string {
+	return checksum.name + ":" + hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// Checksum of an in-memory body, in the same form as digestReader.String
+func checksumOf(data []byte) string {
+	h := checksum.new()
+	h.Write(data)
+	return checksum.name + ":" + hex.EncodeToString(h.Sum(nil))
+}
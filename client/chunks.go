@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A range answered with the whole file, meaning it changed since we started
+var errSourceChanged = errors.New("source changed during chunked download")
+
+// Below splits a download into byte ranges fetched concurrently, for sources
+// that advertise range support, then hands back a response whose body reads
+// the pieces back in order. The HEAD carries -state's conditions, its 304 is
+// handed back as is for the caller to skip the file. touch is called as chunk
+// data arrives, for the idle watchdog. Returns nil whenever the caller should
+// fall back to a single stream.
+func downloadChunked(ctx context.Context, URL string, chunks int, touch func()) *http.Response {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", URL, nil)
+	if err != nil {
+		return nil
+	}
+	if state != nil {
+		state.addConditions(URL, req)
+	}
+	head, err := sourceFetcher.fetch(req)
+	if err != nil {
+		return nil
+	}
+	head.Body.Close()
+	if head.StatusCode == http.StatusNotModified {
+		head.Body = http.NoBody
+		return head
+	}
+	size := head.ContentLength
+	if head.Header.Get("Accept-Ranges") != "bytes" || size < int64(chunks) {
+		return nil
+	}
+	validator := rangeValidator(head.Header)
+
+	files := make([]*os.File, chunks)
+	errs := make([]error, chunks)
+	chunkSize := (size + int64(chunks) - 1) / int64(chunks)
+	var wg sync.WaitGroup
+	for i := range files {
+		start := int64(i) * chunkSize
+		end := start + chunkSize - 1
+		if end >= size {
+			end = size - 1
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			files[i], errs[i] = downloadRange(ctx, URL, validator, start, end, touch)
+		}(i)
+	}
+	wg.Wait()
+
+	body := &chunkedBody{files: files}
+	for _, err := range errs {
+		if err != nil {
+			er.Println("Chunked download failed, falling back to a single stream: ", err)
+			body.Close()
+			return nil
+		}
+	}
+
+	readers := make([]io.Reader, len(files))
+	for i, f := range files {
+		readers[i] = f
+	}
+	body.reader = io.MultiReader(readers...)
+
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        head.Header,
+		ContentLength: size,
+		Body:          body,
+	}
+}
+
+// What to send as If-Range so every chunk comes from the same version of the
+// file, a strong ETag if there is one and Last-Modified otherwise
+func rangeValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// Fetch one inclusive byte range into a temp file, rewound ready for reading
+func downloadRange(ctx context.Context, URL, validator string, start, end int64, touch func()) (*os.File, error) {
+	var lastErr error
+	for i := 0; i < maxRetries; i++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+
+		f, err := fetchRangeTo(req, end-start+1, touch)
+		if err == nil || err == errSourceChanged {
+			return f, err
+		}
+		lastErr = err
+		if ctx.Err() != nil || !budget.take() {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func fetchRangeTo(req *http.Request, length int64, touch func()) (*os.File, error) {
+	resp, err := sourceFetcher.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// With If-Range a full 200 means the file no longer matches the version
+	// the other chunks came from, so none of them can be trusted
+	if resp.StatusCode == http.StatusOK && req.Header.Get("If-Range") != "" {
+		return nil, errSourceChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("expected 206 for range request, got %s", resp.Status)
+	}
+
+	f, err := ioutil.TempFile(conf.spoolDir, "fetch2pi-chunk-")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(f, touchReader{resp.Body, touch})
+	if err == nil && n != length {
+		err = fmt.Errorf("range returned %d bytes, expected %d", n, length)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// Calls touch on every read that returns data
+type touchReader struct {
+	reader io.Reader
+	touch  func()
+}
+
+func (t touchReader) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	if n > 0 {
+		t.touch()
+	}
+	return n, err
+}
+
+// Reads the downloaded chunks back in order, removing them once closed
+type chunkedBody struct {
+	reader io.Reader
+	files  []*os.File
+}
+
+func (c *chunkedBody) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *chunkedBody) Close() error {
+	for _, f := range c.files {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	return nil
+}
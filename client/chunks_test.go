@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDownloadChunkedSendsStateConditions(t *testing.T) {
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", "100")
+	}))
+	defer src.Close()
+	prev := state
+	state = &stateStore{files: map[string]fileState{src.URL + "/f": {ETag: `"v1"`}}}
+	defer func() { state = prev }()
+
+	resp := downloadChunked(runCtx, src.URL+"/f", 2, func() {})
+	if resp == nil || resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("downloadChunked = %v, want the 304 handed back", resp)
+	}
+}
+
+func TestDownloadChunkedStopsWithContext(t *testing.T) {
+	stall := make(chan struct{})
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", "100")
+		if r.Method == "GET" {
+			<-stall
+		}
+	}))
+	defer src.Close()
+	defer close(stall)
+
+	ctx, cancel := context.WithTimeout(runCtx, 100*time.Millisecond)
+	defer cancel()
+	done := make(chan *http.Response)
+	go func() { done <- downloadChunked(ctx, src.URL+"/f", 2, func() {}) }()
+
+	select {
+	case resp := <-done:
+		if resp != nil {
+			t.Error("a cancelled chunked download handed back a response")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled chunks weren't aborted with their context")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A -max-depth-per-pattern override, limit is negative for unlimited
+type depthRule struct {
+	pattern string
+	limit   int
+}
+
+// Set from -max-depth and -max-depth-per-pattern
+var (
+	maxDepth   = -1
+	depthRules []depthRule
+)
+
+// Parse rules like "releases/**=unlimited,*=2". Patterns are path.Match
+// globs where ** also spans any number of directories.
+func parseDepthRules(spec string) ([]depthRule, error) {
+	var rules []depthRule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.LastIndex(part, "=")
+		if i <= 0 {
+			return nil, errors.New("expected pattern=depth, got " + part)
+		}
+
+		rule := depthRule{pattern: strings.Trim(part[:i], "/"), limit: -1}
+		if value := part[i+1:]; value != "unlimited" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.New("depth must be a number or unlimited, got " + value)
+			}
+			rule.limit = n
+		}
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Whether a directory, relative to the crawl root, is shallow enough to list.
+// The longest pattern matching it or one of its parents sets the limit,
+// falling back to -max-depth.
+func depthAllowed(rel string) bool {
+	rel = strings.Trim(rel, "/")
+	segments := strings.Split(rel, "/")
+
+	limit, best := maxDepth, -1
+	for _, rule := range depthRules {
+		if len(rule.pattern) <= best {
+			continue
+		}
+		for n := len(segments); n > 0; n-- {
+			if globMatch(strings.Split(rule.pattern, "/"), segments[:n]) {
+				limit, best = rule.limit, len(rule.pattern)
+				break
+			}
+		}
+	}
+	return limit < 0 || len(segments) <= limit
+}
+
+// path.Match segment by segment, with ** matching zero or more segments
+func globMatch(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if globMatch(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], segments[0])
+	return matched && globMatch(pattern[1:], segments[1:])
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// Below counts what the crawl has found, so deep trees that take a while to
+// list show signs of life before the downloads get going
+type discoveryStats struct {
+	dirs    int64
+	files   int64
+	listing int64
+}
+
+var discovery discoveryStats
+
+// Logged on a ticker, and only while listings are still being crawled
+func (d *discoveryStats) Print() {
+	if atomic.LoadInt64(&d.listing) == 0 {
+		return
+	}
+	dbg.Printf("Crawling: %d directories listed, %d files found so far",
+		atomic.LoadInt64(&d.dirs), atomic.LoadInt64(&d.files))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// With -preserve-empty-dirs, create a directory nothing was relayed into on
+// the sink too. The server takes a WebDAV style MKCOL for it.
+func preserveEmptyDir(dirPath, dest string) {
+	dirPath = withPathCase(cleanSlashes(dirPath))
+	req, err := http.NewRequestWithContext(runCtx, "MKCOL", dest+dirPath, nil)
+	if err != nil {
+		er.Println("Error creating empty directory: ", err)
+		return
+	}
+	resp, err := relayClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("server responded %s", resp.Status)
+		}
+	}
+	if err != nil {
+		er.Println("Error creating empty directory ", dirPath, ": ", err)
+		return
+	}
+	dbg.Println("Created empty directory: ", dirPath)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"strings"
+)
+
+// How much of a file is looked at to decide it isn't text after all
+const eolSniffLen = 8000
+
+// Set from -normalize-eol and -eol-extensions
+var (
+	normalizeEOL string
+	eolExts      []string
+)
+
+// Whether a file's line endings should be rewritten on the way through,
+// only ever for the configured text extensions
+func eolWanted(name string) bool {
+	if normalizeEOL == "" {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range eolExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap a body and its rewind so both come out with normalized line endings
+func normalizedBody(body io.Reader, rewind func() (io.Reader, error)) (io.Reader, func() (io.Reader, error)) {
+	body = newEOLReader(body)
+	if rewind == nil {
+		return body, nil
+	}
+	return body, func() (io.Reader, error) {
+		r, err := rewind()
+		if err != nil {
+			return nil, err
+		}
+		return newEOLReader(r), nil
+	}
+}
+
+// Below rewrites CRLF and LF endings to whichever -normalize-eol asks for,
+// leaving lone CRs alone. Anything with a NUL byte near the start is taken
+// to be binary despite its extension and passed through untouched.
+type eolReader struct {
+	src     *bufio.Reader
+	sniffed bool
+	binary  bool
+	pending bool // the LF of a CRLF that didn't fit last time
+}
+
+func newEOLReader(r io.Reader) *eolReader {
+	return &eolReader{src: bufio.NewReaderSize(r, eolSniffLen)}
+}
+
+func (e *eolReader) Read(p []byte) (int, error) {
+	if !e.sniffed {
+		e.sniffed = true
+		head, _ := e.src.Peek(eolSniffLen)
+		e.binary = bytes.IndexByte(head, 0) >= 0
+	}
+	if e.binary {
+		return e.src.Read(p)
+	}
+
+	n := 0
+	for n < len(p) {
+		if e.pending {
+			p[n] = '\n'
+			n++
+			e.pending = false
+			continue
+		}
+		// Don't block for more once we've something to hand back
+		if n > 0 && e.src.Buffered() == 0 {
+			break
+		}
+
+		c, err := e.src.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if c == '\r' {
+			if next, err := e.src.Peek(1); err == nil && next[0] == '\n' {
+				e.src.ReadByte()
+				c = '\n'
+			}
+		}
+		if c == '\n' && normalizeEOL == "to-crlf" {
+			p[n] = '\r'
+			n++
+			e.pending = true
+			continue
+		}
+		p[n] = c
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Kinds of failure a run can hit, test for them with errors.Is
+var (
+	ErrConfig            = errors.New("invalid configuration")
+	ErrSourceUnreachable = errors.New("source unreachable")
+	ErrDownload          = errors.New("download failed")
+	ErrRelay             = errors.New("relay failed")
+	ErrIntegrity         = errors.New("integrity check failed")
+	ErrInterrupted       = errors.New("interrupted")
+	ErrDeadlineExceeded  = errors.New("deadline exceeded")
+)
+
+// A failure for one URL, carrying which kind it was and what caused it
+type TransferError struct {
+	Kind error
+	URL  string
+	Err  error
+}
+
+func newTransferError(kind error, URL string, err error) error {
+	return &TransferError{Kind: kind, URL: URL, Err: err}
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("%v: %s: %v", e.Kind, e.URL, e.Err)
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransferError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Wraps a failure that retrying can't fix, so it's given up on straight away
+type permanentError struct {
+	error
+}
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// Exit codes the CLI finishes with for each kind of failure
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrConfig):
+		return 2
+	case errors.Is(err, ErrSourceUnreachable):
+		return 3
+	case errors.Is(err, ErrDownload):
+		return 4
+	case errors.Is(err, ErrRelay):
+		return 5
+	case errors.Is(err, ErrIntegrity):
+		return 6
+	case errors.Is(err, ErrInterrupted):
+		return 130
+	case errors.Is(err, ErrDeadlineExceeded):
+		return 124
+	default:
+		return 1
+	}
+}
+
+// Log the error and exit with the code for its kind
+func fatal(err error) {
+	er.Output(2, err.Error())
+	os.Exit(exitCode(err))
+}
+
+func fatalConfig(v ...interface{}) {
+	fatal(fmt.Errorf("%w: %s", ErrConfig, fmt.Sprint(v...)))
+}
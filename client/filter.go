@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// Decide whether a crawled link should be followed, given its path relative
+// to the crawl root. Directories ending in / are kept if anything under them
+// could still be wanted.
+func wanted(rel string) bool {
+	if conf.pathPrefix != "" {
+		if isDirectory(rel) {
+			if !underPrefix(rel) && !strings.HasPrefix(conf.pathPrefix, rel) {
+				return false
+			}
+		} else if !underPrefix(rel) {
+			return false
+		}
+	}
+	if isDirectory(rel) && !depthAllowed(rel) {
+		return false
+	}
+	return !ignored(rel)
+}
+
+// Whether rel is the -path-prefix or under it, matching whole segments only
+// so docs doesn't take in docs-old/
+func underPrefix(rel string) bool {
+	prefix := strings.TrimSuffix(conf.pathPrefix, "/")
+	return rel == prefix || strings.HasPrefix(rel, prefix+"/")
+}
+
+// Whether a file's size is within -min-file-size and -max-file-size, asking
+// the source for it only when a limit is set. Unknown sizes are let through
+// unless -skip-unknown-size.
+func sizeWanted(URL string) bool {
+	if conf.minSize == 0 && conf.maxSize == 0 {
+		return true
+	}
+
+	size, err := headSize(URL)
+	if err != nil || size < 0 {
+		return !conf.skipUnknownSize
+	}
+	return sizeInLimits(size)
+}
+
+func sizeInLimits(size int64) bool {
+	if conf.maxSize > 0 && size > int64(conf.maxSize) {
+		return false
+	}
+	return size >= int64(conf.minSize)
+}
+
+// A crawled URL's path relative to the root it was found under, the longest
+// matching one when roots nest
+func relPath(URL string) string {
+	root := ""
+	for _, r := range crawlRoots {
+		if strings.HasPrefix(URL, r.URL) && len(r.URL) > len(root) {
+			root = r.URL
+		}
+	}
+	return strings.TrimPrefix(URL, root)
+}
+
+// Below remembers every path queued so far, so nothing is transferred twice.
+// With -case-insensitive, paths differing only in case count as the same,
+// since they'd collide on FAT or other case-insensitive sinks anyway.
+type seenSet struct {
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+var seen = seenSet{paths: map[string]string{}}
+
+// Record path as queued, false if it (or a case variant) already was
+func (s *seenSet) claim(path string) bool {
+	key := path
+	if conf.caseInsensitive {
+		key = strings.ToLower(path)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.paths[key]; ok {
+		if prev != path {
+			dbg.Printf("Skipping %s, a case variant of %s", path, prev)
+		}
+		return false
+	}
+	s.paths[key] = path
+	return true
+}
+
+// Below remembers the URL each crawled directory was actually served from,
+// for -skip-symlink-dirs. A source aliasing latest/ to v2.3/ by redirecting
+// would otherwise have the whole tree mirrored twice.
+type visitedSet struct {
+	mu   sync.Mutex
+	dirs map[string]string
+}
+
+var visitedDirs = visitedSet{dirs: map[string]string{}}
+
+// Record a directory by where it resolved to, false if that was already
+// crawled through some other URL
+func (v *visitedSet) claim(dirURL, resolved string) bool {
+	if resolved == "" {
+		resolved = dirURL
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if prev, ok := v.dirs[resolved]; ok && prev != dirURL {
+		dbg.Printf("Skipping %s, it resolves to %s already crawled as %s", dirURL, resolved, prev)
+		return false
+	}
+	v.dirs[resolved] = dirURL
+	return true
+}
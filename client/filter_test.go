@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestWantedPathPrefixMatchesWholeSegments(t *testing.T) {
+	defer func() { conf.pathPrefix = "" }()
+	tests := []struct {
+		prefix, rel string
+		want        bool
+	}{
+		{"docs", "docs/", true},
+		{"docs", "docs/a.txt", true},
+		{"docs", "docs-old/", false},
+		{"docs", "docs-old/a.txt", false},
+		{"docs/", "docs/a.txt", true},
+		{"docs/", "docs-old/a.txt", false},
+		{"docs/sub/", "docs/", true},
+		{"docs/sub/", "docs/sub/a.txt", true},
+		{"docs/sub/", "docs/subway/", false},
+		{"docs/sub/", "docs/a.txt", false},
+	}
+	for _, tt := range tests {
+		conf.pathPrefix = tt.prefix
+		if got := wanted(tt.rel); got != tt.want {
+			t.Errorf("with -path-prefix %q, wanted(%q) = %v, want %v", tt.prefix, tt.rel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// Split a -loc like https://host/releases/v2.*/ into its parent listing and
+// the pattern for the final segment. Only that last segment may hold a glob,
+// anything earlier is taken literally.
+func splitLocPattern(loc string) (string, string) {
+	trimmed := strings.TrimSuffix(loc, "/")
+	i := strings.LastIndex(trimmed, "/")
+	if i < 0 || !strings.ContainsAny(trimmed[i+1:], "*?[") {
+		return loc, ""
+	}
+	return trimmed[:i+1], trimmed[i+1:]
+}
+
+// List the parent once and only crawl the child directories matching the
+// pattern, each landing in outDir under its own name
+func visitMatches(parentURL, pattern, outDir, dest string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for _, href := range listLinks(parentURL) {
+		if !isDirectory(href) {
+			continue
+		}
+
+		matched, err := path.Match(pattern, strings.TrimSuffix(href, "/"))
+		if err != nil || !matched {
+			continue
+		}
+
+		dbg.Println("Pattern matched directory: ", href)
+		wg.Add(1)
+		go visitPage(parentURL+href, outDir+href, dest, wg)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+)
+
+// Below is -head-first. Files found while crawling are only HEADed for their
+// size, and downloads start once every root's listing is done, so the
+// -report-interval line has a real total to count down from.
+type plannedFile struct {
+	URL  string
+	path string
+}
+
+type headPlan struct {
+	mu    sync.Mutex
+	files []plannedFile
+}
+
+var plan headPlan
+
+// HEAD a crawled file and hold it back for download
+func planFile(URL, path string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	releaseDownload := downloadSlots.acquire()
+	release := hosts.acquire(URL)
+	size, err := headSize(URL)
+	release()
+	releaseDownload()
+	if err != nil {
+		dbg.Println("Couldn't HEAD, size unknown for: ", URL, err)
+		size = -1
+	}
+	transfers.plan(size)
+
+	plan.mu.Lock()
+	plan.files = append(plan.files, plannedFile{URL: URL, path: path})
+	plan.mu.Unlock()
+}
+
+// Download everything the crawl found
+func (p *headPlan) download(dest string) {
+	p.mu.Lock()
+	files := p.files
+	p.files = nil
+	p.mu.Unlock()
+	if total := transfers.plannedTotal(); total >= 0 {
+		dbg.Printf("Crawl done, downloading %d files, %.1f MB in total", len(files), float64(total)/1e6)
+	} else {
+		dbg.Printf("Crawl done, downloading %d files of unknown size", len(files))
+	}
+
+	var wg sync.WaitGroup
+	for _, f := range files {
+		if interrupted() {
+			break
+		}
+		wg.Add(1)
+		go proxyFile(f.URL, f.path, dest, &wg)
+	}
+	wg.Wait()
+}
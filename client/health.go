@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// Below watches the sink during a run with -dest-health-interval, holding
+// back new downloads while it's unreachable or short on disk rather than
+// fetching files it can't store
+type destHealth struct {
+	paused     int32
+	dest       string
+	minPercent float64
+}
+
+var health destHealth
+
+func (d *destHealth) start(dest string, interval time.Duration, minPercent float64) func() {
+	d.dest, d.minPercent = dest, minPercent
+	d.check()
+	return scheduleAtInterval(d.check, interval)
+}
+
+func (d *destHealth) check() {
+	err := d.probe()
+	if err != nil {
+		if atomic.SwapInt32(&d.paused, 1) == 0 {
+			er.Println("Destination unhealthy, pausing new downloads: ", err)
+		}
+		return
+	}
+	if atomic.SwapInt32(&d.paused, 0) == 1 {
+		dbg.Println("Destination healthy again, resuming downloads")
+	}
+}
+
+// Asks the server's /stats for free space
+func (d *destHealth) probe() error {
+	// /stats lives at the server's root whatever path -to relays under
+	statsURL, err := url.Parse(d.dest)
+	if err != nil {
+		return err
+	}
+	resp, err := relayClient.Get(statsURL.ResolveReference(&url.URL{Path: "/stats"}).String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stats responded %s", resp.Status)
+	}
+	var stats struct {
+		FreePercent float64 `json:"freePercent"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return err
+	}
+	if stats.FreePercent < d.minPercent {
+		return fmt.Errorf("only %.1f%% free, need at least %.1f%%", stats.FreePercent, d.minPercent)
+	}
+	return nil
+}
+
+// Block until the destination is healthy, or the run is interrupted
+func (d *destHealth) wait() {
+	for atomic.LoadInt32(&d.paused) == 1 && !interrupted() {
+		time.Sleep(time.Second)
+	}
+}
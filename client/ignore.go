@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// One line of an ignore file, see loadIgnoreFile
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Rules from -ignore-file, checked in order with the last match winning
+var ignoreRules []ignoreRule
+
+// Read a .gitignore style file of path.Match globs. Blank lines and # comments
+// are skipped, a leading ! re-includes what an earlier line excluded, a
+// trailing / only matches directories, and a pattern with a / in it matches
+// from the crawl root rather than against any name.
+func loadIgnoreFile(file string) ([]ignoreRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Whether a path relative to the crawl root is excluded by the ignore rules
+func ignored(rel string) bool {
+	if unescaped, err := url.PathUnescape(rel); err == nil {
+		rel = unescaped
+	}
+	dir := isDirectory(rel)
+	rel = strings.TrimSuffix(rel, "/")
+
+	excluded := false
+	for _, rule := range ignoreRules {
+		if rule.dirOnly && !dir {
+			continue
+		}
+		target := rel
+		if !rule.anchored {
+			target = path.Base(rel)
+		}
+		if matched, _ := path.Match(rule.pattern, target); matched {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
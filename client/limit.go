@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+)
+
+// Below caps how many requests are in flight to each origin at once, so a tree
+// spanning several hosts is polite to each of them and one slow host can't
+// hold up the others
+type hostLimiter struct {
+	mu    sync.Mutex
+	limit int
+	slots map[string]chan struct{}
+}
+
+var hosts = hostLimiter{slots: map[string]chan struct{}{}}
+
+// Block until the URL's host has a free slot, returning the func to free it
+func (h *hostLimiter) acquire(rawURL string) func() {
+	if h.limit <= 0 {
+		return func() {}
+	}
+
+	host := hostOf(rawURL)
+	h.mu.Lock()
+	slot, ok := h.slots[host]
+	if !ok {
+		slot = make(chan struct{}, h.limit)
+		h.slots[host] = slot
+	}
+	h.mu.Unlock()
+
+	slot <- struct{}{}
+	var once sync.Once
+	return func() { once.Do(func() { <-slot }) }
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host
+}
+
+// A counting semaphore, nil meaning unlimited
+type semaphore chan struct{}
+
+// Separate pools for directory listings and file downloads, so slow downloads
+// can't starve discovery of new directories or the other way around
+var (
+	listSlots     semaphore
+	downloadSlots semaphore
+	// Set with -relay-concurrency, to relay spooled downloads separately
+	relaySlots semaphore
+)
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// Block until a slot is free, returning the func to free it, which is safe
+// to call more than once
+func (s semaphore) acquire() func() {
+	if s == nil {
+		return func() {}
+	}
+	s <- struct{}{}
+	var once sync.Once
+	return func() { once.Do(func() { <-s }) }
+}
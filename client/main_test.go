@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleAtIntervalStopEndsGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+	var stops []func()
+	for i := 0; i < 50; i++ {
+		stops = append(stops, scheduleAtInterval(func() {}, time.Millisecond))
+	}
+	for _, stop := range stops {
+		stop()
+		// Safe to call again, as deferred stops often are
+		stop()
+	}
+
+	// The goroutines exit asynchronously, give them a moment
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines before, %d after stopping", before, after)
+	}
+}
+
+func TestWithTrailingSlash(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"a", "a/"},
+		{"a/", "a/"},
+	}
+	for _, tt := range tests {
+		if got := withTrailingSlash(tt.in); got != tt.want {
+			t.Errorf("withTrailingSlash(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsDirectory(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"", false},
+		{"/", true},
+		{"a", false},
+		{"a/", true},
+	}
+	for _, tt := range tests {
+		if got := isDirectory(tt.in); got != tt.want {
+			t.Errorf("isDirectory(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRelayEmptyFile(t *testing.T) {
+	src := mapSource{base: "http://source.test/", files: map[string][]byte{
+		"empty.txt": {},
+	}}
+	useSource(t, src)
+	sink := newRecordingSink(t)
+	var out bytes.Buffer
+	prev := dbg
+	dbg = log.New(&out, "DEBUG: ", 0)
+	defer func() { dbg = prev }()
+
+	crawlInto(src, "empty", sink)
+
+	got, ok := sink.get("/empty/empty.txt")
+	if !ok {
+		t.Fatal("empty file was never relayed")
+	}
+	if len(got) != 0 {
+		t.Errorf("empty file relayed as %d bytes", len(got))
+	}
+	if strings.Contains(out.String(), "NaN") {
+		t.Errorf("progress output has a NaN:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "empty/empty.txt 0 bytes transferred") {
+		t.Errorf("no progress line for the empty file:\n%s", out.String())
+	}
+}
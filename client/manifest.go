@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// What we know about one mirrored file, keyed in the manifest by its path
+// under -out as the source listed it, see manifestKey
+type manifestEntry struct {
+	Size int64 `json:"size"`
+	// As algo:hex, so manifests made with different -checksum-algo can coexist
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Below collects an entry for every file seen during a crawl, so the tree can
+// be written out and compared against an earlier run
+type manifest struct {
+	mu    sync.Mutex
+	files map[string]manifestEntry
+}
+
+var (
+	// Nil unless -manifest or -diff need the current tree recorded
+	current *manifest
+	// The -diff manifest from a previous run
+	previous *manifest
+	// Only transfer files that are new or changed relative to previous
+	deltaOnly bool
+)
+
+func newManifest() *manifest {
+	return &manifest{files: map[string]manifestEntry{}}
+}
+
+// Path may also be a server's /manifest URL, which lists what it has stored
+// in the same format, so a run can be compared against the sink directly.
+// That lists the whole serving directory, so only what's under sinkPrefix,
+// the path of -to, is kept and relative to it like our own paths are.
+func loadManifest(path, sinkPrefix string) (*manifest, error) {
+	var data []byte
+	var err error
+	fromSink := isManifestURL(path)
+	if fromSink {
+		data, err = fetchManifest(path)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files map[string]manifestEntry
+	err = json.Unmarshal(data, &files)
+	if err != nil {
+		return nil, err
+	}
+	m := newManifest()
+	for p, entry := range files {
+		p = manifestKey(p)
+		if fromSink {
+			if !strings.HasPrefix(p, sinkPrefix) {
+				continue
+			}
+			p = strings.TrimPrefix(p, sinkPrefix)
+		}
+		m.files[p] = entry
+	}
+	return m, nil
+}
+
+func isManifestURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// Paths are kept unescaped, as the server's /manifest has them, so they line
+// up however a source escaped its hrefs
+func manifestKey(path string) string {
+	if p, err := url.PathUnescape(path); err == nil {
+		return p
+	}
+	return path
+}
+
+func fetchManifest(URL string) ([]byte, error) {
+	resp, err := relayClient.Get(URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", URL, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (m *manifest) add(path string, entry manifestEntry) {
+	m.mu.Lock()
+	m.files[manifestKey(path)] = entry
+	m.mu.Unlock()
+}
+
+// Whether anything was recorded under the directory path dir
+func (m *manifest) hasUnder(dir string) bool {
+	dir = manifestKey(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path := range m.files {
+		if strings.HasPrefix(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *manifest) get(path string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.files[manifestKey(path)]
+	return entry, ok
+}
+
+func (m *manifest) save(path string) error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m.files, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Keep a skipped file's previous entry, so it isn't reported as removed
+func carryOver(path string) {
+	if current == nil || previous == nil {
+		return
+	}
+	if old, ok := previous.get(path); ok {
+		current.add(path, old)
+	}
+}
+
+// Sizes always have to agree, checksums only count when both sides have one
+// made with the same algorithm
+func (e manifestEntry) changedFrom(old manifestEntry) bool {
+	if e.Size != old.Size {
+		return true
+	}
+	algo := strings.SplitN(e.Checksum, ":", 2)[0]
+	if e.Checksum == "" || !strings.HasPrefix(old.Checksum, algo+":") {
+		return false
+	}
+	return e.Checksum != old.Checksum
+}
+
+type manifestDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+func diffManifests(old, cur *manifest) manifestDiff {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+
+	d := manifestDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for path, entry := range cur.files {
+		prev, ok := old.files[path]
+		if !ok {
+			d.Added = append(d.Added, path)
+		} else if entry.changedFrom(prev) {
+			d.Changed = append(d.Changed, path)
+		}
+	}
+	for path := range old.files {
+		if _, ok := cur.files[path]; !ok {
+			d.Removed = append(d.Removed, path)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+func printDiff(d manifestDiff) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// Learn a source file's size without downloading it, -1 if it isn't reported
+func headSize(URL string) (int64, error) {
+	resp, err := sourceRequest("HEAD", URL)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
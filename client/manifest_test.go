@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDiffAgainstSinkManifest(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"mirror/out/a b.txt": {"size": 5},
+			"mirror/out/gone.txt": {"size": 1},
+			"elsewhere/c.txt": {"size": 3}
+		}`))
+	}))
+	defer sink.Close()
+
+	old, err := loadManifest(sink.URL+"/manifest", "mirror/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur := newManifest()
+	cur.add("out/a%20b.txt", manifestEntry{Size: 5})
+	cur.add("out/new.txt", manifestEntry{Size: 2})
+
+	got := diffManifests(old, cur)
+	want := manifestDiff{
+		Added:   []string{"out/new.txt"},
+		Removed: []string{"out/gone.txt"},
+		Changed: []string{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff = %+v, want %+v", got, want)
+	}
+}
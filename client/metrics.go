@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Write per-file timings, sizes and retries in the OpenMetrics text format,
+// for scraping or graphing across many runs
+func (r *resultLog) saveMetrics(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	r.mu.Lock()
+	metrics := []struct {
+		name, help string
+		value      func(fileResult) float64
+	}{
+		{"fetch2pi_file_fetch_seconds", "Time until the source started sending the file", func(res fileResult) float64 { return res.FetchSeconds }},
+		{"fetch2pi_file_relay_seconds", "Time spent streaming the file to the sink", func(res fileResult) float64 { return res.RelaySeconds }},
+		{"fetch2pi_file_seconds", "Total time spent on the file, including retries", func(res fileResult) float64 { return res.Seconds }},
+		{"fetch2pi_file_bytes", "Bytes transferred for the file", func(res fileResult) float64 { return float64(res.Bytes) }},
+		{"fetch2pi_file_retries", "Retries needed for the file", func(res fileResult) float64 { return float64(res.Retries) }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# TYPE %s gauge\n# HELP %s %s\n", m.name, m.name, m.help)
+		for _, res := range r.results {
+			fmt.Fprintf(w, "%s{path=\"%s\",status=\"%s\"} %g\n",
+				m.name, escapeLabel(res.Path), res.Status, m.value(res))
+		}
+	}
+	r.mu.Unlock()
+	fmt.Fprintln(w, "# EOF")
+
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// Set from -relay-encoding, how relay bodies are framed:
+//	chunked  always chunked, with the Digest as a trailer (the default)
+//	length   Content-Length when the source gave one, chunked otherwise
+//	buffer   always Content-Length, spooling files of unknown size first
+// For proxies that refuse chunked uploads. A body with a Content-Length
+// can't carry trailers, so its Digest is only sent when it was buffered.
+var relayEncoding = "chunked"
+
+// Frame req's body per -relay-encoding, returning anything to clean up
+// once it's been sent
+func frameRelayBody(req *http.Request, dr *digestReader) (func(), error) {
+	if relayEncoding == "chunked" || (relayEncoding == "length" && dr.length < 0) {
+		req.Trailer = http.Header{"Digest": nil}
+		dr.req = req
+		return func() {}, nil
+	}
+
+	if dr.length >= 0 {
+		setRelayBody(req, dr, dr.length)
+		return func() {}, nil
+	}
+
+	// Reading it all through dr first means the Digest is known up front
+	f, err := ioutil.TempFile(conf.spoolDir, "fetch2pi-relay-")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	n, err := io.Copy(f, dr)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	setRelayBody(req, f, n)
+	if req.Header.Get("Digest") == "" {
+		req.Header.Set("Digest", checksum.token+"="+base64.StdEncoding.EncodeToString(dr.hash.Sum(nil)))
+	}
+	return cleanup, nil
+}
+
+func setRelayBody(req *http.Request, body io.Reader, length int64) {
+	req.ContentLength = length
+	req.Body = ioutil.NopCloser(body)
+	// Otherwise an empty body is taken to be of unknown length
+	if length == 0 {
+		req.Body = http.NoBody
+	}
+	req.GetBody = nil
+}
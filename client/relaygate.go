@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Below is -relay-health-backoff, easing off a sink that's answering 5xx or
+// 429, or not answering at all, rather than piling more uploads onto it. Each
+// of those halves how many relays may be in flight and pauses new ones for a
+// while, each success lets one more through again, until it's back to the
+// usual limits.
+type relayGate struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	enabled bool
+	// 0 while the sink is healthy, no limit beyond the usual ones
+	allowed     int
+	inFlight    int
+	failures    int
+	pausedUntil time.Time
+}
+
+var sinkGate = newRelayGate()
+
+func newRelayGate() *relayGate {
+	g := &relayGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// Wait until the sink is fit for another relay, returning the func to call
+// with how it went
+func (g *relayGate) acquire() func(overloaded bool) {
+	if !g.enabled {
+		return func(bool) {}
+	}
+
+	g.mu.Lock()
+	for {
+		if wait := time.Until(g.pausedUntil); wait > 0 {
+			g.mu.Unlock()
+			time.Sleep(wait)
+			g.mu.Lock()
+			continue
+		}
+		if g.allowed == 0 || g.inFlight < g.allowed || interrupted() {
+			break
+		}
+		g.cond.Wait()
+	}
+	g.inFlight++
+	g.mu.Unlock()
+
+	return func(overloaded bool) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.inFlight--
+		if overloaded {
+			g.backOff()
+		} else if g.allowed > 0 {
+			g.failures = 0
+			g.allowed++
+			// Back to having room for everything that wants to go
+			if g.allowed > g.inFlight*2 {
+				dbg.Println("Sink recovered, lifting relay backoff")
+				g.allowed = 0
+			}
+		}
+		g.cond.Broadcast()
+	}
+}
+
+// Called with mu held
+func (g *relayGate) backOff() {
+	g.failures++
+	limit := g.allowed
+	if limit == 0 {
+		limit = g.inFlight + 1
+	}
+	g.allowed = limit / 2
+	if g.allowed < 1 {
+		g.allowed = 1
+	}
+	g.pausedUntil = time.Now().Add(backoff(g.failures))
+	er.Printf("Sink overloaded, pausing relays for %v and allowing %d at once", backoff(g.failures), g.allowed)
+}
+
+// Whether a relay's response says the sink wants fewer of them
+func overloaded(resp *http.Response) bool {
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
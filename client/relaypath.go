@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Set from -relay-path, nil relays to the same path as the source
+var relayPathTmpl *template.Template
+
+var runStarted = time.Now()
+
+// What a -relay-path template can use
+type relayPathVars struct {
+	Path         string // the default relay path, -out plus the source path
+	Dir          string
+	Name         string
+	Ext          string
+	Host         string // source host
+	Date         string // run date as 2006-01-02
+	LastModified time.Time
+	Header       http.Header // source response headers
+}
+
+// Work out where on the sink a file should be stored
+func relayPathFor(URL, defaultPath string, header http.Header) (string, error) {
+	defaultPath = cleanSlashes(defaultPath)
+	if relayPathTmpl == nil {
+		p, err := datePartitioned(defaultPath, header)
+		return withPathCase(p), err
+	}
+
+	vars := relayPathVars{
+		Path:   defaultPath,
+		Dir:    path.Dir(defaultPath),
+		Name:   path.Base(defaultPath),
+		Ext:    path.Ext(defaultPath),
+		Date:   runStarted.Format("2006-01-02"),
+		Header: header,
+	}
+	if u, err := url.Parse(URL); err == nil {
+		vars.Host = u.Host
+	}
+	if lm, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		vars.LastModified = lm
+	}
+
+	var buf bytes.Buffer
+	err := relayPathTmpl.Execute(&buf, vars)
+	if err != nil {
+		return "", err
+	}
+	p, err := cleanRelayPath(buf.String())
+	if err != nil {
+		return "", err
+	}
+	p, err = datePartitioned(p, header)
+	return withPathCase(p), err
+}
+
+// Where on the sink a file found at path is stored, also for the checks made
+// before downloading it. Those pass no header, and when the path depends on
+// the source's response the source is asked for one with a HEAD.
+func sinkPathFor(URL, path string, header http.Header) (string, error) {
+	if header == nil && (relayPathTmpl != nil || datePartitionSource == "mtime" || conf.contentDisposition) {
+		resp, err := sourceRequest("HEAD", URL)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		header = resp.Header
+	}
+	return relayPathFor(URL, dispositionPath(path, header), header)
+}
+
+// Set from -relay-path-case, lower or upper, empty storing paths as they are
+var relayPathCase string
+
+// Recase a relay path for sinks that expect one case. Paths differing only in
+// case would then collide, so the crawl treats them as the same file, see
+// seenSet.
+func withPathCase(p string) string {
+	switch relayPathCase {
+	case "lower":
+		return strings.ToLower(p)
+	case "upper":
+		return strings.ToUpper(p)
+	}
+	return p
+}
+
+// Set from -date-partition, a time layout like 2006/01 that every relay path
+// is put under, and -date-partition-source, whether that's the run's date or
+// the file's Last-Modified
+var datePartition, datePartitionSource string
+
+// Prefix a relay path with its date partition, if there is one. Files without
+// a usable Last-Modified fall back to the run's date.
+func datePartitioned(p string, header http.Header) (string, error) {
+	if datePartition == "" {
+		return p, nil
+	}
+
+	t := runStarted
+	if datePartitionSource == "mtime" {
+		if lm, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+			t = lm
+		}
+	}
+	return cleanRelayPath(t.Format(datePartition) + "/" + p)
+}
+
+// With -content-disposition, swap a file's name for the one the source gives
+// in Content-Disposition, for listings linking to download handlers rather
+// than the files themselves
+func dispositionPath(defaultPath string, header http.Header) string {
+	if !conf.contentDisposition {
+		return defaultPath
+	}
+	_, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		return defaultPath
+	}
+	// Only ever a name, never somewhere else in the tree
+	name := params["filename"]
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return defaultPath
+	}
+	// Escaped like the hrefs the rest of the path came from
+	return path.Join(path.Dir(defaultPath), url.PathEscape(name))
+}
+
+// Tidy the doubled slashes and ./ segments messy hrefs leave in a path, so
+// the sink doesn't grow a//b or a/./b, keeping any trailing slash. Relay paths
+// are relative to -to, so a leading slash is dropped rather than doubling
+// the one it ends in.
+func cleanSlashes(p string) string {
+	cleaned := strings.TrimLeft(path.Clean(p), "/")
+	if cleaned == "." {
+		cleaned = ""
+	}
+	if strings.HasSuffix(p, "/") && cleaned != "" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// Keep rendered paths relative and inside the sink's tree
+func cleanRelayPath(p string) (string, error) {
+	cleaned := path.Clean(strings.TrimLeft(p, "/"))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.New("relay path escapes the sink: " + p)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCleanSlashes(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"a/b", "a/b"},
+		{"a//b", "a/b"},
+		{"out//sub//file.txt", "out/sub/file.txt"},
+		{"./a/b", "a/b"},
+		{"a/./b", "a/b"},
+		{"a/.//b/", "a/b/"},
+		{"/a/b", "a/b"},
+		{"//a/b", "a/b"},
+		{"a/b/", "a/b/"},
+		{"a//b//", "a/b/"},
+		{"/", ""},
+		{"./", ""},
+	}
+	for _, tt := range tests {
+		if got := cleanSlashes(tt.in); got != tt.want {
+			t.Errorf("cleanSlashes(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// A fixture source whose listings link with doubled slashes and ./ segments
+type messySource struct {
+	mapSource
+	listings map[string][]string
+}
+
+func (m messySource) list(dirURL string) ([]string, string, error) {
+	return m.listings[dirURL], dirURL, nil
+}
+
+func TestSinkStoresCleanPathsForMessyHrefs(t *testing.T) {
+	files := mapSource{base: "http://source.test/", files: map[string][]byte{
+		"./a.txt":       []byte("alpha"),
+		"sub//b.txt":    []byte("bravo"),
+		"sub//.//c.txt": []byte("charlie"),
+	}}
+	src := messySource{files, map[string][]string{
+		"http://source.test/":      {"./a.txt", "sub//"},
+		"http://source.test/sub//": {"b.txt", ".//c.txt"},
+	}}
+	useSource(t, files)
+	sourceLister = src
+	sink := newRecordingSink(t)
+
+	crawlInto(files, "messy", sink)
+
+	want := map[string]string{
+		"/messy/a.txt":     "alpha",
+		"/messy/sub/b.txt": "bravo",
+		"/messy/sub/c.txt": "charlie",
+	}
+	for p, data := range want {
+		got, ok := sink.get(p)
+		if !ok {
+			t.Errorf("nothing stored at %s", p)
+		} else if !bytes.Equal(got, []byte(data)) {
+			t.Errorf("%s stored as %q, want %q", p, got, data)
+		}
+	}
+	if len(sink.files) != len(want) {
+		t.Errorf("sink stored %d files, want %d: %v", len(sink.files), len(want), sink.files)
+	}
+}
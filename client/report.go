@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Below is the -report-interval line, the whole run's throughput at a glance
+// rather than a percentage per file
+type transferStats struct {
+	// Read from sources so far, across every file and attempt
+	bytes  int64
+	active int64
+	// Files whose downloads have begun, and the sizes of those that gave one
+	started   int64
+	sized     int64
+	sizeTotal int64
+	// Files HEADed with -head-first, and the sizes of those that gave one
+	planned      int64
+	plannedSized int64
+	planTotal    int64
+
+	// Only touched from the ticker
+	lastBytes int64
+	lastAt    time.Time
+}
+
+var transfers = transferStats{lastAt: time.Now()}
+
+// Note a download beginning, size being negative when it's unknown
+func (t *transferStats) start(size int64) {
+	atomic.AddInt64(&t.active, 1)
+	atomic.AddInt64(&t.started, 1)
+	if size >= 0 {
+		atomic.AddInt64(&t.sized, 1)
+		atomic.AddInt64(&t.sizeTotal, size)
+	}
+}
+
+// Note a file HEADed ahead of downloading with -head-first
+func (t *transferStats) plan(size int64) {
+	atomic.AddInt64(&t.planned, 1)
+	if size >= 0 {
+		atomic.AddInt64(&t.plannedSized, 1)
+		atomic.AddInt64(&t.planTotal, size)
+	}
+}
+
+// Bytes the HEADs said the run holds, files that gave no size guessed at the
+// average. -1 if none gave one.
+func (t *transferStats) plannedTotal() int64 {
+	sized, total := atomic.LoadInt64(&t.plannedSized), atomic.LoadInt64(&t.planTotal)
+	if sized == 0 {
+		return -1
+	}
+	return total + total/sized*(atomic.LoadInt64(&t.planned)-sized)
+}
+
+func (t *transferStats) finish() {
+	atomic.AddInt64(&t.active, -1)
+}
+
+func (t *transferStats) Print() {
+	now := time.Now()
+	bytes := atomic.LoadInt64(&t.bytes)
+	rate := float64(bytes-t.lastBytes) / now.Sub(t.lastAt).Seconds()
+	t.lastBytes, t.lastAt = bytes, now
+
+	active := atomic.LoadInt64(&t.active)
+	done := atomic.LoadInt64(&summary.relayed) + atomic.LoadInt64(&summary.skipped) + atomic.LoadInt64(&summary.failed)
+	queued := atomic.LoadInt64(&discovery.files) - done - active
+	if queued < 0 {
+		queued = 0
+	}
+
+	// Without -head-first, files not started yet or without a size are
+	// guessed at the average
+	expected := int64(-1)
+	if conf.headFirst {
+		expected = t.plannedTotal()
+	} else if sized, sizeTotal := atomic.LoadInt64(&t.sized), atomic.LoadInt64(&t.sizeTotal); sized > 0 {
+		unsized := atomic.LoadInt64(&t.started) - sized
+		expected = sizeTotal + sizeTotal/sized*(queued+unsized)
+	}
+
+	eta := "unknown"
+	if expected >= 0 && rate > 0 {
+		remaining := float64(expected - bytes)
+		if remaining < 0 {
+			remaining = 0
+		}
+		eta = (time.Duration(remaining/rate) * time.Second).String()
+		if atomic.LoadInt64(&discovery.listing) > 0 {
+			eta += " so far"
+		}
+	}
+
+	progress := fmt.Sprintf("%.1f MB", float64(bytes)/1e6)
+	if conf.headFirst && expected > 0 {
+		progress = fmt.Sprintf("%.1f of %.1f MB (%.0f%%)", float64(bytes)/1e6, float64(expected)/1e6, 100*float64(bytes)/float64(expected))
+	}
+
+	dbg.Printf("Overall: %s transferred, %.2f MB/s, %d active, %d queued, ETA %s",
+		progress, rate/1e6, active, queued, eta)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+const (
+	statusOK      = "ok"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+)
+
+// The outcome of one file, for auditing exactly what happened in a run
+type fileResult struct {
+	Path    string  `json:"path"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	Retries int     `json:"retries"`
+	Status  string  `json:"status"`
+	Error   string  `json:"error,omitempty"`
+	// Sent as X-Request-ID with each relay, as logged by the server
+	RequestID string `json:"requestId,omitempty"`
+
+	// Split of the last attempt, downloads stream while relaying so these
+	// overlap rather than add up
+	FetchSeconds float64 `json:"fetchSeconds"`
+	RelaySeconds float64 `json:"relaySeconds"`
+}
+
+type resultLog struct {
+	mu      sync.Mutex
+	results []fileResult
+}
+
+var results resultLog
+
+func (r *resultLog) add(res fileResult) {
+	r.mu.Lock()
+	r.results = append(r.results, res)
+	r.mu.Unlock()
+}
+
+// Write every result as a line of JSON
+func (r *resultLog) save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(f)
+	for _, res := range r.results {
+		err = enc.Encode(res)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
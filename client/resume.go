@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// One completed file, as a line in the resume journal
+type journalLine struct {
+	Path string `json:"path"`
+	manifestEntry
+}
+
+// Below records every completed file as it finishes, so a run that dies part
+// way can be restarted with -resume and skip what's already done. Lines are
+// only ever appended, so a crash can at worst leave a torn final line, which
+// is ignored on load.
+type resumeJournal struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]manifestEntry
+}
+
+// Nil unless -resume was given
+var journal *resumeJournal
+
+func openJournal(path string) (*resumeJournal, error) {
+	j := &resumeJournal{done: map[string]manifestEntry{}}
+
+	existing, err := os.Open(path)
+	if err == nil {
+		scanner := bufio.NewScanner(existing)
+		for scanner.Scan() {
+			var line journalLine
+			if json.Unmarshal(scanner.Bytes(), &line) == nil {
+				j.done[line.Path] = line.manifestEntry
+			}
+		}
+		existing.Close()
+		if scanner.Err() != nil {
+			return nil, scanner.Err()
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	j.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if len(j.done) > 0 {
+		dbg.Printf("Resuming, %d files already completed", len(j.done))
+	}
+	return j, nil
+}
+
+// Done last time and the source still reports the same size
+func (j *resumeJournal) alreadyDone(URL, path string) (manifestEntry, bool) {
+	j.mu.Lock()
+	entry, ok := j.done[path]
+	j.mu.Unlock()
+	if !ok {
+		return entry, false
+	}
+
+	size, err := headSize(URL)
+	if err != nil || (size >= 0 && size != entry.Size) {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (j *resumeJournal) record(path string, entry manifestEntry) {
+	data, err := json.Marshal(journalLine{Path: path, manifestEntry: entry})
+	if err != nil {
+		er.Println("Error encoding resume journal entry: ", err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done[path] = entry
+	_, err = j.f.Write(append(data, '\n'))
+	if err == nil {
+		err = j.f.Sync()
+	}
+	if err != nil {
+		er.Println("Error writing resume journal: ", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Longest a source or sink's Retry-After is honoured for, past that our own backoff
+// is used
+const maxRetryAfter = 5 * time.Minute
+
+// Run try until it succeeds, fails for good, or the retries run out, backing
+// off in between. Listings and downloads both go through here so they treat
+// an overloaded source the same way. try is told which attempt it's on and
+// logs its own failures.
+func withRetries(URL string, try func(attempt int) error) error {
+	for i := 1; ; i++ {
+		err := try(i)
+		if err == nil {
+			return nil
+		}
+
+		if isPermanent(err) || interrupted() {
+			er.Println("Not retrying: ", URL)
+			return err
+		}
+		if i == maxRetries {
+			er.Println("Reached maximum retry count for: ", URL)
+			return err
+		}
+		// Only source failures say anything about the source host
+		if (errors.Is(err, ErrDownload) || errors.Is(err, ErrSourceUnreachable)) && !hostBudget.take(URL) {
+			return err
+		}
+		if !budget.take() {
+			er.Println("No retry budget left for: ", URL)
+			return err
+		}
+		time.Sleep(retryDelay(i, err))
+	}
+}
+
+// Our backoff, or longer if the source said how long to leave it
+func retryDelay(attempt int, err error) time.Duration {
+	d := backoff(attempt)
+	var se *statusError
+	if errors.As(err, &se) && se.retryAfter > d {
+		d = se.retryAfter
+		if d > maxRetryAfter {
+			d = maxRetryAfter
+		}
+	}
+	return d
+}
+
+// A response that wasn't a 200, or from the sink one asking us to back off,
+// with any Retry-After it came with
+type statusError struct {
+	status     string
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status: " + e.status
+}
+
+// Nil for a 200, otherwise why not. Client errors besides timeouts and rate
+// limiting won't get any better for asking again, so are permanent.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	err := &statusError{
+		status:     resp.Status,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return permanent(err)
+	}
+	return err
+}
+
+// Retry-After is either a number of seconds or a date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// Delay before retry number attempt, doubling each time up to a cap
+func backoff(attempt int) time.Duration {
+	const base, max = time.Second, 30 * time.Second
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Caps the total number of retries across every file in a run, so a degraded
+// network can't keep us thrashing for hours. A negative limit is unlimited.
+type retryBudget struct {
+	mu        sync.Mutex
+	limit     int
+	used      int
+	exhausted bool
+}
+
+var budget = retryBudget{limit: -1}
+
+// Claim a single retry from the budget, returning false once it's all spent
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit < 0 {
+		b.used++
+		return true
+	}
+	if b.used >= b.limit {
+		if !b.exhausted {
+			er.Printf("Retry budget of %d exhausted, further failures will not be retried", b.limit)
+		}
+		b.exhausted = true
+		return false
+	}
+	b.used++
+	return true
+}
+
+func (b *retryBudget) status() (used int, exhausted bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used, b.exhausted
+}
+
+// Caps retries against any one source host with -max-retries-per-host, so a
+// dead mirror is given up on quickly while other hosts carry on
+type hostRetries struct {
+	mu     sync.Mutex
+	limit  int
+	used   map[string]int
+	failed map[string]bool
+}
+
+var hostBudget = hostRetries{limit: -1, used: map[string]int{}, failed: map[string]bool{}}
+
+// Claim a retry against the URL's host, marking the host failed once it's
+// used them all up
+func (h *hostRetries) take(rawURL string) bool {
+	if h.limit < 0 {
+		return true
+	}
+	host := hostOf(rawURL)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.used[host] >= h.limit {
+		if !h.failed[host] {
+			er.Printf("Host %s used all %d of its retries, skipping the rest of its files", host, h.limit)
+		}
+		h.failed[host] = true
+		return false
+	}
+	h.used[host]++
+	return true
+}
+
+// Whether the URL's host has already been given up on
+func (h *hostRetries) dead(rawURL string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failed[hostOf(rawURL)]
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithRetriesRecoversFromSink503(t *testing.T) {
+	var calls int32
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer sink.Close()
+	method := conf.relayMethod
+	conf.relayMethod = "POST"
+	defer func() { conf.relayMethod = method }()
+
+	attempts := 0
+	err := withRetries(sink.URL, func(i int) error {
+		attempts = i
+		dr := newDigestReader(strings.NewReader("data"))
+		return postRelay(runCtx, "http://source.test/f", sink.URL+"/f", dr)
+	})
+	if err != nil {
+		t.Fatalf("withRetries = %v, want success", err)
+	}
+	if retries := attempts - 1; retries != 1 {
+		t.Errorf("retried %d times, want 1", retries)
+	}
+}
+
+func TestSink429IsRetriedAfterRetryAfter(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer sink.Close()
+	method := conf.relayMethod
+	conf.relayMethod = "POST"
+	defer func() { conf.relayMethod = method }()
+
+	dr := newDigestReader(strings.NewReader("data"))
+	err := postRelay(runCtx, "http://source.test/f", sink.URL+"/f", dr)
+	if err == nil || isPermanent(err) {
+		t.Fatalf("postRelay = %v, want a retryable error", err)
+	}
+	if d := retryDelay(1, err); d != 7*time.Second {
+		t.Errorf("retry delay = %v, want the 7s asked for", d)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A tree to mirror, from -loc or a line of -loc-file
+type crawlRoot struct {
+	URL string
+	// Glob for the final segment, see splitLocPattern
+	pattern string
+	outDir  string
+}
+
+// Every root of the run, paths are made relative to whichever holds them
+var crawlRoots []crawlRoot
+
+// Set with -root-concurrency, how many roots are crawled at once
+var rootSlots semaphore
+
+func newCrawlRoot(loc, outDir string) (crawlRoot, error) {
+	if !isValidURL(loc) {
+		return crawlRoot{}, errors.New("not valid URL: " + loc)
+	}
+	root := crawlRoot{outDir: outDir}
+	root.URL, root.pattern = splitLocPattern(withTrailingSlash(loc))
+	if _, err := path.Match(root.pattern, ""); err != nil {
+		return crawlRoot{}, errors.New("not a valid pattern in: " + loc)
+	}
+	return root, nil
+}
+
+// Read -loc-file, a root URL per line optionally followed by its output
+// directory. Without one it goes under -out, named after the URL's last
+// segment. Blank lines and # comments are skipped.
+func loadLocFile(file, baseOut string) ([]crawlRoot, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var roots []crawlRoot
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		outDir := baseOut
+		if len(fields) > 1 {
+			outDir = fields[1]
+		} else if baseOut == "" {
+			return nil, errors.New("no output directory for " + fields[0] + ", give one or set -out")
+		} else if u, err := url.Parse(fields[0]); err == nil {
+			outDir = path.Join(baseOut, path.Base(strings.TrimSuffix(u.Path, "/")))
+		}
+
+		root, err := newCrawlRoot(fields[0], outDir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("no roots in " + file)
+	}
+	return roots, nil
+}
+
+// Crawl every root, up to -root-concurrency at once, all sharing the same
+// download and relay pools, summary and results
+func startDL(roots []crawlRoot, dest string) {
+	stopDiscovery := scheduleAtInterval(func() { discovery.Print() }, 15*time.Second)
+	defer stopDiscovery()
+	if conf.reportInterval > 0 {
+		stopReport := scheduleAtInterval(transfers.Print, conf.reportInterval)
+		defer stopReport()
+	}
+
+	var all sync.WaitGroup
+	for _, root := range roots {
+		// Taken here rather than in the goroutine so roots start in order
+		release := rootSlots.acquire()
+		if interrupted() {
+			release()
+			break
+		}
+		all.Add(1)
+		go func(root crawlRoot) {
+			defer all.Done()
+			defer release()
+			crawl(root, dest)
+		}(root)
+	}
+	all.Wait()
+	if conf.headFirst && !interrupted() {
+		plan.download(dest)
+	}
+}
+
+func crawl(root crawlRoot, dest string) {
+	outDir := withTrailingSlash(root.outDir)
+	dbg.Printf("Fetching directory at: %s, using output directory: %s", root.URL, outDir)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if conf.resumeFrom != "" {
+		// Paths stay relative to -loc, as if the crawl had got here itself
+		dbg.Println("Resuming crawl from: ", conf.resumeFrom)
+		go visitPage(conf.resumeFrom, outDir+relPath(conf.resumeFrom), dest, &wg)
+	} else if root.pattern != "" {
+		go visitMatches(root.URL, root.pattern, outDir, dest, &wg)
+	} else {
+		go visitPage(root.URL, outDir, dest, &wg)
+	}
+	wg.Wait()
+}
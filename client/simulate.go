@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+)
+
+// For testing only: with -simulate-failure-rate this fraction of downloads
+// and relays fail on purpose, to exercise retries, budgets, the breaker and
+// the summary against a real source and server. Left out of -help.
+var simulatedFailureRate float64
+
+var errSimulated = errors.New("simulated failure")
+
+func simulateFailure() bool {
+	return simulatedFailureRate > 0 && rand.Float64() < simulatedFailureRate
+}
+
+// Flags not worth advertising in -help, they still work if given
+var hiddenFlags = map[string]bool{
+	"simulate-failure-rate": true,
+}
+
+func usageWithoutHidden() {
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	visible.PrintDefaults()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A flag for sizes like 10MB or 2GiB. KB, MB and friends are powers of 1000,
+// KiB and bare K powers of 1024, and a plain number is bytes.
+type byteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	num, mult := strings.ToUpper(strings.TrimSpace(s)), float64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(num, unit.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, unit.suffix)), unit.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("not a size: %q", s)
+	}
+	*b = byteSize(n * mult)
+	return nil
+}
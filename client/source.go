@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Below is what the crawl needs from a source, so visitPage and relayFile
+// aren't tied to scraping HTTP directory listings. httpSource is what the
+// command uses, anything that can list and serve paths can stand in for it.
+type lister interface {
+	// The hrefs of every link on the listing at dirURL, and the URL it was
+	// finally served from after any redirects
+	list(dirURL string) ([]string, string, error)
+}
+
+type fetcher interface {
+	// Fetch a file, honouring any conditional or range headers on req
+	fetch(req *http.Request) (*http.Response, error)
+}
+
+var (
+	sourceLister  lister  = httpSource{}
+	sourceFetcher fetcher = httpSource{}
+)
+
+type httpSource struct{}
+
+func (httpSource) list(dirURL string) ([]string, string, error) {
+	resp, err := httpClient.Get(dirURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	err = checkStatus(resp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var body io.Reader = resp.Body
+	if conf.dumpListingDir != "" {
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, "", err
+		}
+		dumpListing(dirURL, data)
+		body = bytes.NewReader(data)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, "", err
+	}
+	// goquery is wonderfully succinct
+	var hrefs []string
+	base := listingBase(dirURL)
+	doc.Find("a").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		if href == "" {
+			return
+		}
+		hrefs = append(hrefs, href)
+		if listingSumSelector != "" && !isDirectory(href) {
+			if sum := listingSum(s); sum != "" {
+				published.add(base+href, sum)
+			}
+		}
+	})
+	if len(hrefs) == 0 {
+		dbg.Println("No links found in listing, see -dump-listing to inspect it: ", dirURL)
+	}
+	return hrefs, resp.Request.URL.String(), nil
+}
+
+// The directory a listing's hrefs are relative to, without the ?page=N of a
+// -follow-pagination page, so its sums are keyed by the file URLs themselves
+func listingBase(dirURL string) string {
+	u, err := url.Parse(dirURL)
+	if err != nil {
+		return dirURL
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String()
+}
+
+// Keep a listing's raw HTML for -dump-listing, named after its path, to see
+// why a source's markup isn't giving up the links expected
+func dumpListing(dirURL string, data []byte) {
+	name := "index"
+	if u, err := url.Parse(dirURL); err == nil {
+		if p := strings.Trim(u.Path, "/"); p != "" {
+			name = strings.ReplaceAll(p, "/", "_")
+		}
+		if u.RawQuery != "" {
+			name += "_" + url.PathEscape(u.RawQuery)
+		}
+	}
+
+	file := filepath.Join(conf.dumpListingDir, name+".html")
+	err := ioutil.WriteFile(file, data, 0644)
+	if err != nil {
+		er.Println("Error dumping listing: ", err)
+	}
+}
+
+func (httpSource) fetch(req *http.Request) (*http.Response, error) {
+	return httpClient.Do(req)
+}
+
+// A bodiless request to the source, like a HEAD for a file's size, made
+// through sourceFetcher so a stand-in source answers it too
+func sourceRequest(method, URL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(runCtx, method, URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sourceFetcher.fetch(req)
+}
+
+// With -retry-empty-listing, an empty listing where the -diff manifest says
+// there should be files is taken to be a source hiccup rather than the truth
+var retryEmptyListing bool
+
+var errEmptyListing = errors.New("listing unexpectedly empty")
+
+// No links to anything under the listing, while the manifest had files there
+func unexpectedlyEmpty(dirPath string, hrefs []string) bool {
+	if !retryEmptyListing {
+		return false
+	}
+	for _, href := range hrefs {
+		if !strings.HasPrefix(href, "/") && !strings.HasPrefix(href, "?") &&
+			!strings.HasPrefix(href, "../") && !strings.Contains(href, "://") {
+			return false
+		}
+	}
+	return previous.hasUnder(dirPath)
+}
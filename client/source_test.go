@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Below stands in for an HTTP source, serving a fixture tree of path to
+// contents under base with no network involved at all
+type mapSource struct {
+	base  string
+	files map[string][]byte
+}
+
+func (m mapSource) list(dirURL string) ([]string, string, error) {
+	dir := strings.TrimPrefix(dirURL, m.base)
+	found := map[string]bool{}
+	var hrefs []string
+	for p := range m.files {
+		if !strings.HasPrefix(p, dir) {
+			continue
+		}
+		href := p[len(dir):]
+		if i := strings.Index(href, "/"); i >= 0 {
+			href = href[:i+1]
+		}
+		if !found[href] {
+			found[href] = true
+			hrefs = append(hrefs, href)
+		}
+	}
+	sort.Strings(hrefs)
+	return hrefs, dirURL, nil
+}
+
+func (m mapSource) fetch(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	data, ok := m.files[strings.TrimPrefix(req.URL.String(), m.base)]
+	if !ok {
+		resp.Status, resp.StatusCode = "404 Not Found", http.StatusNotFound
+		return resp, nil
+	}
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	if req.Method != "HEAD" {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	}
+	return resp, nil
+}
+
+// Crawl from a fixture source for the rest of the test
+func useSource(t *testing.T, src mapSource) {
+	lister, fetcher, method := sourceLister, sourceFetcher, conf.relayMethod
+	sourceLister, sourceFetcher, conf.relayMethod = src, src, "POST"
+	t.Cleanup(func() {
+		sourceLister, sourceFetcher, conf.relayMethod = lister, fetcher, method
+	})
+}
+
+// A sink keeping every relay it's sent, by path
+type recordingSink struct {
+	*httptest.Server
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newRecordingSink(t *testing.T) *recordingSink {
+	s := &recordingSink{files: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.mu.Lock()
+		s.files[r.URL.Path] = data
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordingSink) get(path string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[path]
+	return data, ok
+}
+
+// Crawl a single root into the sink, as a run with just -loc would
+func crawlInto(src mapSource, outDir string, sink *recordingSink) {
+	root := crawlRoot{URL: src.base, outDir: outDir}
+	crawlRoots = []crawlRoot{root}
+	crawl(root, sink.URL+"/")
+}
+
+func TestCrawlRelaysFixtureTree(t *testing.T) {
+	src := mapSource{base: "http://source.test/", files: map[string][]byte{
+		"a.txt":            []byte("alpha"),
+		"sub/b.txt":        []byte("bravo"),
+		"sub/deeper/c.bin": {0, 1, 2, 3},
+	}}
+	useSource(t, src)
+	sink := newRecordingSink(t)
+
+	crawlInto(src, "fixture", sink)
+
+	for p, want := range src.files {
+		got, ok := sink.get("/fixture/" + p)
+		if !ok {
+			t.Errorf("%s was never relayed", p)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s relayed as %q, want %q", p, got, want)
+		}
+	}
+	if len(sink.files) != len(src.files) {
+		t.Errorf("relayed %d files, want %d", len(sink.files), len(src.files))
+	}
+}
+
+func TestHeadSizeUsesSource(t *testing.T) {
+	src := mapSource{base: "http://source.test/", files: map[string][]byte{
+		"a.txt": []byte("alpha"),
+	}}
+	useSource(t, src)
+
+	size, err := headSize(src.base + "a.txt")
+	if err != nil || size != 5 {
+		t.Errorf("headSize = %d, %v, want 5", size, err)
+	}
+}
+
+func TestListingSumsOnLaterPages(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<table><tr><td><a href="f.txt">f.txt</a></td><td class="sum">` + sum + `</td></tr></table>`))
+	}))
+	defer srv.Close()
+	selector := listingSumSelector
+	listingSumSelector = "td.sum"
+	defer func() { listingSumSelector = selector }()
+
+	_, _, err := httpSource{}.list(srv.URL + "/dir/?page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := published.get(srv.URL + "/dir/f.txt"); got != "sha256:"+sum {
+		t.Errorf("published sum = %q, want sha256:%s", got, sum)
+	}
+}
+
+func TestRelayBatchAppliesSizeLimits(t *testing.T) {
+	src := mapSource{base: "http://source.test/", files: map[string][]byte{
+		"tiny.txt":  []byte("a"),
+		"small.txt": []byte("bravo"),
+		"large.txt": bytes.Repeat([]byte("c"), 100),
+		"huge.txt":  bytes.Repeat([]byte("d"), 1000),
+	}}
+	useSource(t, src)
+	sink := newRecordingSink(t)
+	prev := conf
+	conf.batchThreshold, conf.minSize, conf.maxSize = 10, 2, 500
+	t.Cleanup(func() { conf = prev })
+
+	crawlInto(src, "batched", sink)
+
+	tarred, ok := sink.get("/batched/")
+	if !ok {
+		t.Fatal("no batch was relayed")
+	}
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(tarred))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	if len(names) != 1 || names[0] != "small.txt" {
+		t.Errorf("batch held %q, want just small.txt", names)
+	}
+	if _, ok := sink.get("/batched/large.txt"); !ok {
+		t.Error("large.txt wasn't relayed on its own")
+	}
+	for _, p := range []string{"/batched/tiny.txt", "/batched/huge.txt"} {
+		if _, ok := sink.get(p); ok {
+			t.Errorf("%s is outside the size limits but was relayed", p)
+		}
+	}
+}
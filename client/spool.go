@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Below keeps a copy of each download in -spool-dir as it streams past, so a
+// relay that fails can be retried from disk instead of fetching the file
+// again, without ever holding the body in memory
+type spool struct {
+	f *os.File
+}
+
+func newSpool() (*spool, error) {
+	f, err := ioutil.TempFile(conf.spoolDir, "fetch2pi-spool-")
+	if err != nil {
+		return nil, err
+	}
+	return &spool{f: f}, nil
+}
+
+func (s *spool) Close() {
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
+
+// Copy everything read from the source into the spool on the way through
+func (s *spool) tee(r io.Reader) io.Reader {
+	return io.TeeReader(r, s.f)
+}
+
+// Replays the spooled body for retryRelay. The first call finishes the
+// download into the spool, in case the failed relay stopped reading the
+// source part way.
+func (s *spool) rewinder(rest io.Reader) func() (io.Reader, error) {
+	drained := false
+	return func() (io.Reader, error) {
+		if !drained {
+			_, err := io.Copy(s.f, rest)
+			if err != nil {
+				return nil, newTransferError(ErrDownload, s.f.Name(), err)
+			}
+			drained = true
+		}
+		_, err := s.f.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		return s.f, nil
+	}
+}
+
+// Retry just the relay, replaying the body from rewind rather than fetching
+// it again, framed like first was. Returns the digest of the attempt that
+// succeeded.
+func retryRelay(ctx context.Context, rewind func() (io.Reader, error), first *digestReader, URL, relayPath, dest string, lastErr error) (*digestReader, error) {
+	for i := 1; i < maxRetries; i++ {
+		er.Println(lastErr, ", RETRYING RELAY: ", i, ", FOR FILE: ", relayPath)
+		if isPermanent(lastErr) || errors.Is(lastErr, ErrIntegrity) || !budget.take() {
+			break
+		}
+		time.Sleep(backoff(i))
+
+		body, err := rewind()
+		if err != nil {
+			return nil, err
+		}
+		dr := newDigestReader(body)
+		dr.length, dr.published = first.length, first.published
+		lastErr = postRelay(ctx, URL, dest+relayPath, dr)
+		if lastErr == nil {
+			return dr, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// Bounds how much -relay-concurrency lets pile up downloaded but not yet
+// relayed, with -spool-max. A file bigger than the whole limit still goes
+// through, just on its own. One of unknown size waits until there's any room
+// at all, and what it turns out to be is counted once it's spooled.
+type spoolBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit byteSize
+	used  int64
+}
+
+// Enough to keep relays busy without a crawl filling the disk
+const defaultSpoolMax = 1 << 30
+
+var spoolSpace = newSpoolBudget()
+
+func newSpoolBudget() *spoolBudget {
+	b := &spoolBudget{limit: defaultSpoolMax}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Wait for room to spool n more bytes
+func (b *spoolBudget) reserve(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.limit > 0 && b.used > 0 && (b.used+n > int64(b.limit) || b.used >= int64(b.limit)) {
+		b.cond.Wait()
+	}
+	b.used += n
+}
+
+// Count bytes already spooled beyond what was reserved, without waiting as
+// they're on disk either way
+func (b *spoolBudget) grow(n int64) {
+	b.mu.Lock()
+	b.used += n
+	b.mu.Unlock()
+}
+
+func (b *spoolBudget) free(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
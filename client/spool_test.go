@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpoolBudgetHoldsBackUnknownSizesWhenFull(t *testing.T) {
+	b := newSpoolBudget()
+	b.limit = 100
+
+	// Said nothing of its size and turned out to fill the spool
+	b.reserve(0)
+	b.grow(150)
+
+	got := make(chan struct{})
+	go func() {
+		b.reserve(0)
+		close(got)
+	}()
+	select {
+	case <-got:
+		t.Fatal("a file of unknown size was let into a full spool")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.free(150)
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting once the spool emptied")
+	}
+}
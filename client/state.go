@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Cache validators remembered from a previous run for one source file
+type fileState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// Below store persists validators between runs so unchanged files can be
+// skipped with a conditional GET instead of being transferred again
+type stateStore struct {
+	mu    sync.Mutex
+	path  string
+	files map[string]fileState
+}
+
+// Nil unless -state was given, in which case conditional downloads are used
+var state *stateStore
+
+func loadState(path string) (*stateStore, error) {
+	s := &stateStore{path: path, files: map[string]fileState{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &s.files)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add If-None-Match/If-Modified-Since for anything we saw last run
+func (s *stateStore) addConditions(URL string, req *http.Request) {
+	s.mu.Lock()
+	fs, ok := s.files[URL]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if fs.ETag != "" {
+		req.Header.Set("If-None-Match", fs.ETag)
+	}
+	if fs.LastModified != "" {
+		req.Header.Set("If-Modified-Since", fs.LastModified)
+	}
+}
+
+func (s *stateStore) record(URL string, header http.Header) {
+	fs := fileState{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+	if fs.ETag == "" && fs.LastModified == "" {
+		return
+	}
+
+	s.mu.Lock()
+	s.files[URL] = fs
+	s.mu.Unlock()
+}
+
+// Write the state out via a temp file and rename, so a crash mid-save can't
+// leave a truncated state file behind
+func (s *stateStore) save() error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.files, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tallies for the end of run report, updated concurrently by every transfer
+type runSummary struct {
+	relayed int64
+	skipped int64
+	failed  int64
+	bytes   int64
+
+	mu       sync.Mutex
+	firstErr error
+}
+
+var summary runSummary
+
+func (s *runSummary) ok(bytes int64) {
+	atomic.AddInt64(&s.relayed, 1)
+	atomic.AddInt64(&s.bytes, bytes)
+}
+
+func (s *runSummary) skip() {
+	atomic.AddInt64(&s.skipped, 1)
+}
+
+func (s *runSummary) fail(err error) {
+	atomic.AddInt64(&s.failed, 1)
+
+	s.mu.Lock()
+	if s.firstErr == nil {
+		s.firstErr = err
+	}
+	s.mu.Unlock()
+}
+
+// The first failure of the run, which decides the exit code
+func (s *runSummary) firstError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.firstErr
+}
+
+func (s *runSummary) failures() int64 {
+	return atomic.LoadInt64(&s.failed)
+}
+
+func (s *runSummary) Print() {
+	r := s.report()
+	dbg.Printf("Relayed %d files (%d bytes) in %.1fs, %d skipped, %d failed, %d retries used",
+		r.Relayed, r.Bytes, r.Seconds, r.Skipped, r.Failed, r.Retries)
+	if r.BudgetExhausted {
+		er.Printf("Retry budget of %d was exhausted during the run", budget.limit)
+	}
+}
+
+// The same totals as Print, for -summary-format json
+type summaryReport struct {
+	Relayed         int64   `json:"relayed"`
+	Skipped         int64   `json:"skipped"`
+	Failed          int64   `json:"failed"`
+	Bytes           int64   `json:"bytes"`
+	Retries         int     `json:"retries"`
+	Seconds         float64 `json:"seconds"`
+	BudgetExhausted bool    `json:"retryBudgetExhausted"`
+}
+
+func (s *runSummary) report() summaryReport {
+	retries, exhausted := budget.status()
+	return summaryReport{
+		Relayed:         atomic.LoadInt64(&s.relayed),
+		Skipped:         atomic.LoadInt64(&s.skipped),
+		Failed:          s.failures(),
+		Bytes:           atomic.LoadInt64(&s.bytes),
+		Retries:         retries,
+		Seconds:         time.Since(runStarted).Seconds(),
+		BudgetExhausted: exhausted,
+	}
+}
+
+// Write the summary as json, or as prometheus for node_exporter's textfile
+// collector, to -summary-out or stdout. text just logs it like always.
+func (s *runSummary) write(format, path string) error {
+	if format == "text" {
+		s.Print()
+		return nil
+	}
+
+	out := os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	r := s.report()
+	if format == "json" {
+		return json.NewEncoder(out).Encode(r)
+	}
+
+	w := bufio.NewWriter(out)
+	metrics := []struct {
+		name, help string
+		value      float64
+	}{
+		{"fetch2pi_files_relayed", "Files relayed in the last run", float64(r.Relayed)},
+		{"fetch2pi_files_skipped", "Files skipped in the last run", float64(r.Skipped)},
+		{"fetch2pi_files_failed", "Files that failed in the last run", float64(r.Failed)},
+		{"fetch2pi_bytes_relayed", "Bytes relayed in the last run", float64(r.Bytes)},
+		{"fetch2pi_retries", "Retries used in the last run", float64(r.Retries)},
+		{"fetch2pi_run_seconds", "How long the last run took", r.Seconds},
+		{"fetch2pi_last_run_timestamp_seconds", "When the last run finished", float64(time.Now().Unix())},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", m.name, m.help, m.name, m.name, m.value)
+	}
+	return w.Flush()
+}
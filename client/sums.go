@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Below picks up checksum files like SHA256SUMS that releases publish next to
+// their files, both to skip what the server already holds and to verify what
+// we download
+type publishedSums struct {
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+var published = publishedSums{sums: map[string]string{}}
+
+// Fetch and parse the -sums-file in a directory, if it has one
+func (p *publishedSums) load(dirURL string) {
+	resp, err := sourceRequest("GET", dirURL+conf.sumsFile)
+	if err != nil {
+		er.Println("Error fetching checksum file: ", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	sums, err := parseSums(resp.Body)
+	if err != nil {
+		er.Println("Error reading checksum file: ", err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, sum := range sums {
+		p.sums[dirURL+name] = sum
+	}
+	dbg.Printf("Loaded %d checksums from %s", len(sums), dirURL+conf.sumsFile)
+}
+
+// Record a checksum found some other way, like in the listing itself
+func (p *publishedSums) add(URL, sum string) {
+	p.mu.Lock()
+	p.sums[URL] = sum
+	p.mu.Unlock()
+}
+
+// The published checksum for a file as algo:hex, empty if there isn't one
+func (p *publishedSums) get(URL string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sums[URL]
+}
+
+// Lines are sha256sum's "<hex>  <name>", or "<hex> *<name>" for binary mode,
+// and the algorithm is told apart by the digest's length
+func parseSums(r io.Reader) (map[string]string, error) {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		algo := sumAlgo(fields[0])
+		if algo == "" {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimLeft(fields[1], " *"), "./")
+		sums[name] = algo + ":" + strings.ToLower(fields[0])
+	}
+	return sums, scanner.Err()
+}
+
+func sumAlgo(digest string) string {
+	if _, err := hex.DecodeString(digest); err != nil {
+		return ""
+	}
+	switch len(digest) {
+	case 64:
+		return "sha256"
+	case 40:
+		return "sha1"
+	case 32:
+		return "md5"
+	}
+	return ""
+}
+
+// Whether the server advertises a Digest matching the published checksum
+func serverHasSum(URL, sum string) bool {
+	resp, err := relayClient.Head(URL)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	parts := strings.SplitN(sum, ":", 2)
+	return digestFor(resp.Header, checksumAlgos[parts[0]].token) == parts[1]
+}
+
+// The hex checksum an RFC 3230 Digest header gives for one algorithm, like
+// the server's SHA-256=<base64>, empty if it has none
+func digestFor(h http.Header, token string) string {
+	for _, d := range strings.Split(h.Get("Digest"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(d), "=", 2)
+		if len(kv) != 2 || !strings.EqualFold(kv[0], token) {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(kv[1])
+		if err == nil {
+			return hex.EncodeToString(raw)
+		}
+	}
+	return ""
+}
+
+// Compare a finished download to its published checksum, which can only be
+// done when it was hashed with the same algorithm
+func checkPublishedSum(URL, got string) error {
+	want := published.get(URL)
+	if want == "" || !strings.HasPrefix(want, checksum.name+":") {
+		return nil
+	}
+	if got != want {
+		return newTransferError(ErrIntegrity, URL,
+			fmt.Errorf("checksum %s doesn't match published %s", got, want))
+	}
+	return nil
+}
+
+// Set from -checksum-selector, where a listing row keeps each file's checksum:
+// a goquery selector relative to the row, with an optional @attr to read an
+// attribute of what it matches rather than its text
+var listingSumSelector, listingSumAttr string
+
+// Pick the checksum for a link out of its listing row, if it has one. A
+// leading algo: or algo= is allowed, the digest's length says which it is.
+func listingSum(link *goquery.Selection) string {
+	row := link.Closest("tr")
+	if row.Length() == 0 {
+		row = link.Parent()
+	}
+	cell := row.Find(listingSumSelector).First()
+
+	var text string
+	if listingSumAttr != "" {
+		text, _ = cell.Attr(listingSumAttr)
+	} else {
+		text = cell.Text()
+	}
+	text = strings.TrimSpace(text)
+	if i := strings.IndexAny(text, ":="); i >= 0 {
+		text = text[i+1:]
+	}
+	algo := sumAlgo(text)
+	if algo == "" {
+		return ""
+	}
+	return algo + ":" + strings.ToLower(text)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Below backs -test, checking both ends are usable without mirroring anything
+// so a bad config shows up in seconds rather than after a long crawl
+func testConnection(roots []crawlRoot, dest string) error {
+	var srcErr error
+	for _, root := range roots {
+		err := testSource(root.URL)
+		if err != nil {
+			er.Println("Source check failed: ", err)
+			srcErr = err
+		} else {
+			dbg.Println("Source OK: ", root.URL)
+		}
+	}
+
+	destErr := testDestination(dest)
+	if destErr != nil {
+		er.Println("Destination check failed: ", destErr)
+	} else {
+		dbg.Println("Destination OK: ", dest)
+	}
+
+	if srcErr != nil {
+		return srcErr
+	}
+	return destErr
+}
+
+// The source should answer with a listing that has links in it
+func testSource(loc string) error {
+	hrefs, _, err := sourceLister.list(loc)
+	var status *statusError
+	if errors.As(err, &status) && (strings.HasPrefix(status.status, "401") || strings.HasPrefix(status.status, "403")) {
+		err = fmt.Errorf("authentication refused: %s", status.status)
+	}
+	if err != nil {
+		return newTransferError(ErrSourceUnreachable, loc, explainConnError(err))
+	}
+	if len(hrefs) == 0 {
+		return newTransferError(ErrSourceUnreachable, loc,
+			errors.New("page has no links, doesn't look like a directory listing"))
+	}
+	return nil
+}
+
+// The sink should allow our relay method
+func testDestination(dest string) error {
+	req, err := http.NewRequest("OPTIONS", dest, nil)
+	if err != nil {
+		return newTransferError(ErrRelay, dest, err)
+	}
+	resp, err := relayClient.Do(req)
+	if err != nil {
+		return newTransferError(ErrRelay, dest, explainConnError(err))
+	}
+	resp.Body.Close()
+	if err := statusProblem(resp); err != nil {
+		return newTransferError(ErrRelay, dest, err)
+	}
+	if allow := resp.Header.Get("Allow"); !strings.Contains(allow, conf.relayMethod) {
+		return newTransferError(ErrRelay, dest,
+			fmt.Errorf("server doesn't accept uploads, allows only %q", allow))
+	}
+	return nil
+}
+
+func statusProblem(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("authentication refused: %s", resp.Status)
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("server responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Call out certificate trouble, which otherwise reads like any other failure
+func explainConnError(err error) error {
+	var unknown x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &unknown) || errors.As(err, &hostname) || errors.As(err, &invalid) {
+		return fmt.Errorf("TLS certificate problem: %w", err)
+	}
+	return err
+}
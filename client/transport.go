@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// One transport is shared by every request the client makes, so connection
+// reuse and proxy settings apply to listings, downloads and relays alike.
+// Requests to the source and to the sink get their own clients though, so
+// each side can have its own -source-timeout and -relay-timeout.
+var (
+	transport   = http.DefaultTransport.(*http.Transport).Clone()
+	httpClient  = &http.Client{Transport: transport}
+	relayClient = &http.Client{Transport: transport}
+)
+
+// Keep enough idle connections around that many small relays reuse them
+// instead of dialling the server for every file. HTTP/2 is negotiated over
+// TLS when both ends support it, unless disabled.
+func tuneConnections(maxIdlePerHost int, idleTimeout time.Duration, http2 bool) {
+	transport.MaxIdleConnsPerHost = maxIdlePerHost
+	if transport.MaxIdleConns < maxIdlePerHost {
+		transport.MaxIdleConns = maxIdlePerHost
+	}
+	transport.IdleConnTimeout = idleTimeout
+	if !http2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+}
+
+// Only talk TLS to the relay server if its certificate chain carries one of
+// the given base64 SHA-256 SPKI pins, as in HPKP, so even a trusted CA can't
+// be used to intercept uploads. Relays get a transport of their own for it,
+// so every sink connection is checked however the server is addressed, an IP
+// sends no server name to tell it apart by. Sources are verified as normal.
+// Needs calling after anything else that changes the shared transport.
+func pinRelay(pins []string) error {
+	want := map[string]bool{}
+	for _, pin := range pins {
+		pin = strings.TrimSpace(pin)
+		raw, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(raw) != sha256.Size {
+			return errors.New("not a base64 SHA-256 pin: " + pin)
+		}
+		want[pin] = true
+	}
+
+	pinned := transport.Clone()
+	if pinned.TLSClientConfig == nil {
+		pinned.TLSClientConfig = &tls.Config{}
+	}
+	pinned.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		for _, cert := range cs.PeerCertificates {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if want[base64.StdEncoding.EncodeToString(sum[:])] {
+				return nil
+			}
+		}
+		return errors.New("relay certificate matches none of the pinned keys")
+	}
+	relayClient.Transport = pinned
+	return nil
+}
+
+// Dial other addresses for some host:ports, like curl's --connect-to, given
+// as host:port:ip:port. Only where the connection goes changes, the Host
+// header and TLS server name are still the original host's, so a sink can be
+// reached by IP where its name doesn't resolve and still route by vhost.
+func connectTo(rules []string) error {
+	overrides := map[string]string{}
+	for _, rule := range rules {
+		parts := strings.SplitN(strings.TrimSpace(rule), ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return errors.New("not host:port:ip:port: " + rule)
+		}
+		ip, port, err := net.SplitHostPort(parts[2])
+		if err != nil || ip == "" || port == "" {
+			return errors.New("not host:port:ip:port: " + rule)
+		}
+		overrides[net.JoinHostPort(parts[0], parts[1])] = net.JoinHostPort(ip, port)
+	}
+
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if to, ok := overrides[addr]; ok {
+			addr = to
+		}
+		return dial(ctx, network, addr)
+	}
+	return nil
+}
+
+// Send everything through a SOCKS5 proxy, given as host:port or as a
+// socks5:// URL when credentials are needed
+func useSOCKS5(addr string) error {
+	if !strings.Contains(addr, "://") {
+		addr = "socks5://" + addr
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return errors.New("proxy scheme must be socks5")
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return err
+	}
+
+	// net/http dials socks5 proxies itself, no extra dependency needed
+	transport.Proxy = http.ProxyURL(u)
+	dbg.Println("Dialing through SOCKS5 proxy at ", u.Host)
+	return nil
+}
+
+// Below logs every request and response's headers for -verbose-http, to debug
+// auth, redirects and content negotiation against awkward sources
+type verboseTransport struct {
+	next http.RoundTripper
+}
+
+func (v verboseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	dbg.Printf("> %s %s%s", req.Method, req.URL, formatHeaders(req.Header))
+	resp, err := v.next.RoundTrip(req)
+	if err != nil {
+		dbg.Printf("< %s %s: %v", req.Method, req.URL, err)
+		return nil, err
+	}
+	dbg.Printf("< %s %s%s", resp.Status, req.URL, formatHeaders(resp.Header))
+	return resp, nil
+}
+
+func formatHeaders(h http.Header) string {
+	var b strings.Builder
+	for name, values := range h {
+		for _, v := range values {
+			if name == "Authorization" || name == "Proxy-Authorization" {
+				v = "REDACTED"
+			}
+			b.WriteString("\n\t" + name + ": " + v)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Below checks an existing mirror against the source using only HEADs on both
+// sides, so nothing is downloaded or uploaded
+type verifyReport struct {
+	mu         sync.Mutex
+	verified   int
+	missing    []string
+	mismatched []string
+}
+
+var verification verifyReport
+
+func verifyFile(URL, path, dest string) {
+	src, err := sourceRequest("HEAD", URL)
+	if err != nil {
+		er.Println("Error checking source file: ", err)
+		summary.fail(newTransferError(ErrSourceUnreachable, URL, err))
+		return
+	}
+	src.Body.Close()
+
+	// Wherever a relay would have stored it, -relay-path, partitions and all
+	relayPath, err := sinkPathFor(URL, path, src.Header)
+	if err != nil {
+		summary.fail(newTransferError(ErrConfig, URL, err))
+		return
+	}
+	sink, err := relayClient.Head(dest + relayPath)
+	if err != nil {
+		er.Println("Error checking server file: ", err)
+		summary.fail(newTransferError(ErrRelay, dest+relayPath, err))
+		return
+	}
+	sink.Body.Close()
+
+	verification.mu.Lock()
+	defer verification.mu.Unlock()
+	if sink.StatusCode == http.StatusNotFound {
+		verification.missing = append(verification.missing, path)
+	} else if src.ContentLength >= 0 && src.ContentLength != sink.ContentLength {
+		verification.mismatched = append(verification.mismatched, path)
+	} else if !checksumsAgree(src.Header, sink.Header) {
+		verification.mismatched = append(verification.mismatched, path)
+	} else {
+		verification.verified++
+	}
+}
+
+// Only a disagreement counts, a side without a checksum can't be compared
+func checksumsAgree(src, sink http.Header) bool {
+	a, b := headerSHA256(src), headerSHA256(sink)
+	return a == "" || b == "" || a == b
+}
+
+// A response's SHA-256 in hex, from a Digest header like the server sends
+// with -sidecars or -checksum-cache, or a source's X-Content-SHA256
+func headerSHA256(h http.Header) string {
+	if sum := digestFor(h, "SHA-256"); sum != "" {
+		return sum
+	}
+	return strings.ToLower(h.Get("X-Content-SHA256"))
+}
+
+func (v *verifyReport) problems() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.missing) + len(v.mismatched)
+}
+
+func (v *verifyReport) Print() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, path := range v.missing {
+		er.Println("Missing on server: ", path)
+	}
+	for _, path := range v.mismatched {
+		er.Println("Does not match source: ", path)
+	}
+	dbg.Printf("Verified %d files, %d missing, %d mismatched",
+		v.verified, len(v.missing), len(v.mismatched))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// With -extract-archives, uploads named .tar, .tar.gz or .tgz are unpacked
+// into the directory they were sent to as they stream in, rather than stored
+// as the archive itself
+var extractArchives bool
+
+func isArchive(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".tar") || isGzipArchive(lower)
+}
+
+func isGzipArchive(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+func extractArchive(w http.ResponseWriter, req *http.Request, name string) {
+	dbg.Println("Extracting archive upload: ", name)
+	extractTar(w, req, filepath.Dir(name), isGzipArchive(name))
+}
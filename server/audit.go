@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Below is the -audit-log, a JSON line per file that made it to disk. Unlike
+// the request log it only records stored files, and each line is synced
+// before the upload is answered so the record survives a crash.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Nil unless -audit-log is set
+var audit *auditLog
+
+type auditRecord struct {
+	Time         time.Time `json:"time"`
+	RemoteAddr   string    `json:"remoteAddr"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	ChecksumAlgo string    `json:"checksumAlgo"`
+	Checksum     string    `json:"checksum"`
+	RequestID    string    `json:"requestId,omitempty"`
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f}, nil
+}
+
+// Record a stored file, the request ID being the one serveLogger answered with
+func (a *auditLog) record(w http.ResponseWriter, req *http.Request, name string, size int64, h hash.Hash) {
+	if a == nil {
+		return
+	}
+
+	line, err := json.Marshal(auditRecord{
+		Time:         time.Now(),
+		RemoteAddr:   req.RemoteAddr,
+		Path:         "/" + filepath.ToSlash(name),
+		Size:         size,
+		ChecksumAlgo: checksum.token,
+		Checksum:     hex.EncodeToString(h.Sum(nil)),
+		RequestID:    w.Header().Get(requestIDHeader),
+	})
+	if err != nil {
+		er.Println("Error encoding audit record: ", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(append(line, '\n'))
+	if err == nil {
+		err = a.f.Sync()
+	}
+	if err != nil {
+		er.Println("Error writing audit log: ", err)
+	}
+}
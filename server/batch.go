@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// Marks a POST as a tar of several files, extracted relative to the directory
+// it was posted to
+const batchHeader = "X-Fetch2pi-Batch"
+
+// Unpack a batch upload into dir
+func extractBatch(w http.ResponseWriter, req *http.Request, dir string) {
+	extractTar(w, req, dir, false)
+}
+
+// A tar entry written out and waiting for the whole archive to check out
+type stagedEntry struct {
+	name   string
+	staged *stagedUpload
+}
+
+// Unpack a tar stream, gzipped or not, into dir. Entries are staged to temp
+// files as they stream in, and only once the whole body matches the Digest
+// the client sent are they stored, each like a single upload would be. Entry
+// names are cleaned so none can land outside dir.
+func extractTar(w http.ResponseWriter, req *http.Request, dir string, gzipped bool) {
+	err := os.MkdirAll(filepath.Join(".", dir), dirPerm)
+	if err != nil {
+		logServError(w, "Error creating wrapping directories", err)
+		return
+	}
+
+	var entries []stagedEntry
+	discardAll := func() {
+		for _, e := range entries {
+			e.staged.discard()
+		}
+	}
+
+	digest := checksum.new()
+	raw := io.TeeReader(req.Body, digest)
+	var body io.Reader = raw
+	if gzipped {
+		gz, err := gzip.NewReader(raw)
+		if err != nil {
+			logServError(w, "Error reading gzipped archive", err)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	tr := tar.NewReader(body)
+	buf := make([]byte, copyBufferSize)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			discardAll()
+			logServError(w, "Error reading batch", err)
+			return
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := filepath.Join(".", dir, filepath.FromSlash(path.Clean("/"+hdr.Name)))
+		if !extensionAllowed(name) {
+			er.Println("Skipping batch entry with disallowed extension: ", name)
+			continue
+		}
+		staged, err := stageUpload(filepath.Join(".", dir), tr, buf)
+		if err != nil {
+			discardAll()
+			logServError(w, "Error staging batch entry "+hdr.Name, err)
+			return
+		}
+		entries = append(entries, stagedEntry{name: name, staged: staged})
+	}
+
+	// Whatever follows the end of the archive is still part of the body the
+	// Digest covers, and reading to the end is what fills in a trailer
+	_, err = io.Copy(ioutil.Discard, raw)
+	if err != nil {
+		discardAll()
+		logServError(w, "Error reading batch", err)
+		return
+	}
+	if !digestMatches(req, digest) {
+		discardAll()
+		er.Println("Checksum mismatch, discarded batch for: ", dir)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte("Checksum mismatch"))
+		return
+	}
+
+	stored, conflicts := 0, 0
+	for i, e := range entries {
+		name, err := storeEntry(e.name, e.staged, req)
+		if err == errConflict {
+			er.Println("Skipping batch entry for existing file: ", e.name)
+			conflicts++
+			continue
+		} else if err != nil {
+			for _, rest := range entries[i+1:] {
+				rest.staged.discard()
+			}
+			logServError(w, "Error storing batch entry "+e.name, err)
+			return
+		}
+		audit.record(w, req, name, e.staged.size, e.staged.hash)
+		stored++
+		storedManifest.invalidate()
+		runOnComplete(name)
+	}
+
+	msg := strconv.Itoa(stored) + " files stored"
+	if conflicts > 0 {
+		msg += ", " + strconv.Itoa(conflicts) + " skipped as existing"
+	}
+	w.Write([]byte(msg))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// A hash uploads can be verified with, token is its RFC 3230 name
+type checksumAlgo struct {
+	token string
+	new   func() hash.Hash
+}
+
+var checksumAlgos = map[string]checksumAlgo{
+	"sha256": {"SHA-256", sha256.New},
+	"sha1":   {"SHA", sha1.New},
+	"md5":    {"MD5", md5.New},
+}
+
+// Chosen with -checksum-algo, should match what clients send
+var checksum = checksumAlgos["sha256"]
+
+// Compare an upload's hash against the Digest the client sent, either up front
+// or as a trailer once the body is read. Uploads without a digest for our
+// algorithm can't be checked and are let through.
+func digestMatches(req *http.Request, h hash.Hash) bool {
+	digest := req.Header.Get("Digest")
+	if digest == "" {
+		digest = req.Trailer.Get("Digest")
+	}
+
+	for _, part := range strings.Split(digest, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || !strings.EqualFold(kv[0], checksum.token) {
+			continue
+		}
+		return kv[1] == base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+
+	if digest != "" {
+		er.Println("No ", checksum.token, " digest to verify against in: ", digest)
+	}
+	return true
+}
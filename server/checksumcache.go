@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How often new cache entries are written out to -checksum-cache
+const checksumCacheFlush = 30 * time.Second
+
+// Below remembers the SHA-256 of stored files without sidecars, keyed by
+// path and only trusted while the size and mtime still match, so Digest
+// headers for an unchanged mirror don't mean rereading every byte each time
+type checksumCache struct {
+	mu      sync.Mutex
+	file    string
+	entries map[string]cachedChecksum
+	dirty   bool
+}
+
+type cachedChecksum struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	SHA256  string `json:"sha256"`
+}
+
+// Set from -checksum-cache, nil leaves files without sidecars unhashed
+var sumCache *checksumCache
+
+// Load the on-disk index if there is one, and keep it up to date from then on
+func loadChecksumCache(file string) (*checksumCache, error) {
+	c := &checksumCache{file: file, entries: map[string]cachedChecksum{}}
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(data, &c.entries)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	go func() {
+		for range time.Tick(checksumCacheFlush) {
+			err := c.save()
+			if err != nil {
+				er.Println("Error saving checksum cache: ", err)
+			}
+		}
+	}()
+	return c, nil
+}
+
+// The hex SHA-256 of a stored file, hashing it only if it's new or changed
+func (c *checksumCache) sum(root, name string) string {
+	key := path.Clean("/" + name)
+	file := filepath.Join(root, filepath.FromSlash(key))
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() {
+		return entry.SHA256
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+
+	entry = cachedChecksum{Size: info.Size(), ModTime: info.ModTime().UnixNano(), SHA256: hex.EncodeToString(h.Sum(nil))}
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.dirty = true
+	c.mu.Unlock()
+	return entry.SHA256
+}
+
+func (c *checksumCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, filePerm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, c.file)
+	if err == nil {
+		c.dirty = false
+	}
+	return err
+}
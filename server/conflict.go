@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Reports where an upload was actually stored, which -on-conflict rename can
+// make differ from where it was sent
+const storedAsHeader = "X-Fetch2pi-Stored-As"
+
+// What to do with an upload for a path that already exists, from -on-conflict
+var onConflict = "overwrite"
+
+var errConflict = errors.New("file already exists")
+
+// Pick the path to store an upload at under the -on-conflict policy. Rename
+// and reject claim the path with an empty file so concurrent uploads can't
+// both end up with it.
+func resolveConflict(name string) (string, error) {
+	if onConflict == "overwrite" {
+		return name, nil
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = base + "-" + strconv.Itoa(i) + ext
+		}
+		f, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePerm)
+		if err == nil {
+			f.Close()
+			return candidate, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		if onConflict == "reject" {
+			return "", errConflict
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+)
+
+// With -dedupe, uploads are stored once as blobs named by their SHA-256 and
+// every path holding the same bytes is a hardlink to that blob. Never by
+// -checksum-algo, as an md5 or sha1 collision would have two different uploads
+// share one blob.
+var (
+	dedupe  bool
+	blobDir string
+)
+
+func createBlobTemp() (*os.File, error) {
+	if tempDir != "" {
+		return createUploadTemp(tempDir)
+	}
+	return createUploadTemp(blobDir)
+}
+
+// Move a finished upload into the blob store, or drop it if that blob already
+// exists, then point name at the blob. h is the upload's SHA-256.
+func linkBlob(tmp string, h hash.Hash, name string) error {
+	blob := filepath.Join(blobDir, hex.EncodeToString(h.Sum(nil)))
+	if _, err := os.Stat(blob); err == nil {
+		dbg.Println("Duplicate content, reusing blob for: ", name)
+		os.Remove(tmp)
+	} else {
+		err = os.MkdirAll(blobDir, dirPerm)
+		if err == nil {
+			err = moveFile(tmp, blob)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	os.Remove(name)
+	err := os.Link(blob, name)
+	if err != nil {
+		// Some filesystems can't hardlink, a symlink still serves fine
+		abs, absErr := filepath.Abs(blob)
+		if absErr != nil {
+			return err
+		}
+		return os.Symlink(abs, name)
+	}
+	return nil
+}
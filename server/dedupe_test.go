@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobsNamedBySHA256WhateverTheChecksumAlgo(t *testing.T) {
+	dirPerm, filePerm = 0755, 0644
+	chdir(t, t.TempDir())
+	prevDedupe, prevBlobs, prevAlgo := dedupe, blobDir, checksum
+	dedupe, blobDir, checksum = true, ".blobs", checksumAlgos["md5"]
+	t.Cleanup(func() { dedupe, blobDir, checksum = prevDedupe, prevBlobs, prevAlgo })
+
+	staged, err := stageUpload(".", strings.NewReader("hello"), make([]byte, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.place("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("hello"))
+	if _, err := os.Stat(filepath.Join(".blobs", hex.EncodeToString(sum[:]))); err != nil {
+		t.Errorf("no blob named by SHA-256: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// With -allow-delete, DELETE removes a file along with its sidecars, or with
+// ?recursive=true a whole directory, for pruning old mirrors
+var allowDelete bool
+
+func deletePath(w http.ResponseWriter, req *http.Request) {
+	name := filepath.Join(".", filepath.FromSlash(path.Clean("/"+req.URL.Path)))
+	if name == "." {
+		er.Println("Refusing to delete the storage root")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	// Blobs are only ever removed along with every path linking to them
+	if blobs := treePath(blobDir); blobs != "" && (within(name, blobs) || within(blobs, name)) {
+		er.Println("Refusing to delete the blob store: ", name)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// Not while an upload to the same path is being put in place
+	unlock, ok := uploadLocks.acquire(name, !rejectConcurrent)
+	if !ok {
+		er.Println("Rejecting delete during an upload to: ", name)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	info, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		logServError(w, "Error finding file to delete", err)
+		return
+	}
+
+	deleted := 0
+	if info.IsDir() {
+		if req.URL.Query().Get("recursive") != "true" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Use ?recursive=true to delete a directory"))
+			return
+		}
+		filepath.Walk(name, func(p string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() && !isSidecar(p) {
+				deleted++
+			}
+			return nil
+		})
+		err = os.RemoveAll(name)
+	} else {
+		deleted = 1
+		err = os.Remove(name)
+		os.Remove(name + checksumSidecarExt)
+		os.Remove(name + metadataSidecarExt)
+	}
+	if err != nil {
+		logServError(w, "Error deleting", err)
+		return
+	}
+
+	dbg.Printf("Deleted %d files under %s", deleted, name)
+	storedManifest.invalidate()
+	w.Write([]byte(strconv.Itoa(deleted) + " files deleted"))
+}
+
+// Whether path is dir or somewhere under it
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
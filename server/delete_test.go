@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestDeleteRefusesBlobStoreAndBusyPaths(t *testing.T) {
+	dirPerm, filePerm = 0755, 0644
+	chdir(t, t.TempDir())
+	prevBlobs, prevReject := blobDir, rejectConcurrent
+	blobDir, rejectConcurrent = ".blobs", true
+	t.Cleanup(func() { blobDir, rejectConcurrent = prevBlobs, prevReject })
+	for _, dir := range []string{".blobs", "busy"} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dir+"/f", []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unlock, _ := uploadLocks.acquire("busy/f", false)
+	defer unlock()
+
+	for path, want := range map[string]int{
+		"/.blobs/f":              http.StatusForbidden,
+		"/.blobs?recursive=true": http.StatusForbidden,
+		"/busy/f":                http.StatusConflict,
+	} {
+		w := httptest.NewRecorder()
+		deletePath(w, httptest.NewRequest("DELETE", path, nil))
+		if w.Code != want {
+			t.Errorf("DELETE %s = %d, want %d", path, w.Code, want)
+		}
+	}
+	for _, name := range []string{".blobs/f", "busy/f"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s was deleted", name)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// Percentage of the filesystem holding dir that's available to us
+func freePercent(dir string) (float64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+	if st.Blocks == 0 {
+		return 0, nil
+	}
+	return float64(st.Bavail) / float64(st.Blocks) * 100, nil
+}
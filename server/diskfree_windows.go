@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "errors"
+
+func freePercent(dir string) (float64, error) {
+	return 0, errors.New("free space checks are not supported on windows")
+}
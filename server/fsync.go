@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// With -fsync, uploads are flushed to disk before we answer, so a Pi losing
+// power straight after can't lose a file the client thinks is stored. Each
+// upload then waits on the SD card, which costs a lot of throughput for many
+// small files.
+var fsyncUploads bool
+
+// Flush a directory so entries created or renamed in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Flush everything that was touched while placing an upload at name
+func syncPlacement(name string) error {
+	if dedupe {
+		err := syncDir(blobDir)
+		if err != nil {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(name))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Command run after each successful upload, from -on-complete. Template
+// variables, substituted per argument:
+//
+//	{{path}} - the stored file's path, relative to the serving directory
+//	{{dir}}  - the directory the file was stored in
+//	{{name}} - the stored file's base name
+//
+// Without -on-complete-shell the command is split on whitespace and run
+// directly, so a substituted path is always a single argument and can't
+// inject anything. With it, the command goes through sh -c and substituted
+// values are single quoted.
+var (
+	onComplete      string
+	onCompleteShell bool
+)
+
+func runOnComplete(name string) {
+	if onComplete == "" {
+		return
+	}
+	cmd := completeCommand(name)
+
+	go func() {
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			er.Printf("on-complete for %s failed (%v): %s", name, err, out)
+			return
+		}
+		dbg.Printf("on-complete for %s exited 0: %s", name, out)
+	}()
+}
+
+// Substituted in a single pass, so a name that itself holds {{name}} or the
+// like is never expanded again inside what's already been quoted
+func completeCommand(name string) *exec.Cmd {
+	if onCompleteShell {
+		r := strings.NewReplacer(
+			"{{path}}", shellQuote(name),
+			"{{dir}}", shellQuote(filepath.Dir(name)),
+			"{{name}}", shellQuote(filepath.Base(name)),
+		)
+		return exec.Command("sh", "-c", r.Replace(onComplete))
+	}
+
+	r := strings.NewReplacer(
+		"{{path}}", name,
+		"{{dir}}", filepath.Dir(name),
+		"{{name}}", filepath.Base(name),
+	)
+	args := strings.Fields(onComplete)
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	return exec.Command(args[0], args[1:]...)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
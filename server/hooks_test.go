@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Names holding placeholders of their own, which must come through as the
+// literal text they are
+var placeholderNames = []string{
+	"d/{{name}};touch PWNED;",
+	"{{dir}}/{{path}}'x",
+	"a/{{path}}",
+}
+
+func TestCompleteCommandShellSubstitutesOnce(t *testing.T) {
+	chdir(t, t.TempDir())
+	command, shell := onComplete, onCompleteShell
+	onComplete, onCompleteShell = "printf %s {{path}}", true
+	t.Cleanup(func() { onComplete, onCompleteShell = command, shell })
+
+	for _, name := range placeholderNames {
+		out, err := completeCommand(name).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%q: %v: %s", name, err, out)
+		}
+		if string(out) != name {
+			t.Errorf("%q echoed as %q", name, out)
+		}
+	}
+	if _, err := os.Stat("PWNED"); err == nil {
+		t.Error("a substituted name ran a command of its own")
+	}
+}
+
+func TestCompleteCommandArgsSubstituteOnce(t *testing.T) {
+	command, shell := onComplete, onCompleteShell
+	onComplete, onCompleteShell = "notify {{dir}} {{name}}", false
+	t.Cleanup(func() { onComplete, onCompleteShell = command, shell })
+
+	cmd := completeCommand("{{name}}/{{dir}}")
+	want := []string{"notify", "{{name}}", "{{dir}}"}
+	if !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("args = %q, want %q", cmd.Args, want)
+	}
+}
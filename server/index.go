@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Checksums stored alongside a file, in sha256sum's output format
+const checksumSidecarExt = ".sha256"
+
+// Set from -index-template, nil keeps the fileserver's own listing
+var indexTmpl *template.Template
+
+// What a custom index template gets to render
+type indexPage struct {
+	Path    string
+	Entries []indexEntry
+}
+
+type indexEntry struct {
+	Name     string
+	IsDir    bool
+	Size     int64
+	ModTime  time.Time
+	Checksum string
+}
+
+func loadIndexTemplate(file string) (*template.Template, error) {
+	return template.New(path.Base(file)).Funcs(template.FuncMap{
+		"humanize": humanizeBytes,
+	}).ParseFiles(file)
+}
+
+// Render a directory listing with the custom template, returning false if the
+// request isn't for a directory we should render so the fileserver handles it
+func serveIndex(w http.ResponseWriter, r *http.Request, root http.Dir) bool {
+	// Leave directories with their own index.html to the fileserver
+	if idx, err := root.Open(path.Join(r.URL.Path, "index.html")); err == nil {
+		idx.Close()
+		return false
+	}
+
+	entries, ok := readIndex(root, r.URL.Path)
+	if !ok {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := indexTmpl.Execute(w, indexPage{Path: r.URL.Path, Entries: entries})
+	if err != nil {
+		er.Println("Error rendering index template: ", err)
+	}
+	return true
+}
+
+// With -index-json, directory GETs asking for JSON get their listing as that
+// instead of HTML, so tooling needn't scrape it
+var indexJSON bool
+
+type jsonIndexEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// Like serveIndex, but as a JSON array of entries
+func serveJSONIndex(w http.ResponseWriter, r *http.Request, root http.Dir) bool {
+	entries, ok := readIndex(root, r.URL.Path)
+	if !ok {
+		return false
+	}
+
+	list := make([]jsonIndexEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, jsonIndexEntry{Name: e.Name, Size: e.Size, ModTime: e.ModTime, IsDir: e.IsDir})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(list)
+	if err != nil {
+		er.Println("Error writing JSON index: ", err)
+	}
+	return true
+}
+
+// The entries of a directory, sidecars aside, false if urlPath isn't one
+func readIndex(root http.Dir, urlPath string) ([]indexEntry, bool) {
+	if !strings.HasSuffix(urlPath, "/") {
+		return nil, false
+	}
+
+	dir, err := root.Open(urlPath)
+	if err != nil {
+		return nil, false
+	}
+	defer dir.Close()
+
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, false
+	}
+
+	var entries []indexEntry
+	for _, info := range infos {
+		name := info.Name()
+		if isSidecar(name) {
+			continue
+		}
+		entry := indexEntry{
+			Name:    name,
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if !entry.IsDir {
+			entry.Checksum = sidecarChecksum(string(root), path.Join(urlPath, name))
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, true
+}
+
+// The checksum recorded next to a stored file, if there is one
+func sidecarChecksum(root, name string) string {
+	file := filepath.Join(root, filepath.FromSlash(path.Clean("/"+name)))
+	data, err := ioutil.ReadFile(file + checksumSidecarExt)
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Below is a log destination that appends to a file and reopens it on SIGHUP,
+// so logrotate can move the old file out from under a long running process
+type reopenableFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func openLogFile(path string) (*reopenableFile, error) {
+	r := &reopenableFile{path: path}
+	err := r.reopen()
+	if err != nil {
+		return nil, err
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			err := r.reopen()
+			if err != nil {
+				er.Println("Error reopening log file: ", err)
+			}
+		}
+	}()
+	return r, nil
+}
+
+func (r *reopenableFile) reopen() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	old := r.f
+	r.f = f
+	r.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (r *reopenableFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Write(p)
+}
+
+// Point the debug and error loggers somewhere other than stdout/stderr
+func setLogOutput(debug, errors io.Writer) {
+	dbg.SetOutput(debug)
+	er.SetOutput(errors)
+}
+
+func useLogFile(path string) {
+	f, err := openLogFile(path)
+	if err != nil {
+		er.Fatal("Error opening log file: ", err)
+	}
+	setLogOutput(f, f)
+}
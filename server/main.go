@@ -1,16 +1,17 @@
 package main
 
 import (
-	"io"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const port = ":8321"
-const createPerm = os.ModePerm
 
 // 1MB copy buffer
 const copyBufferSize = 1024 * 1024
@@ -20,6 +21,19 @@ var (
 	er  *log.Logger
 )
 
+// Permissions applied to everything the sink creates, set from flags
+var (
+	dirPerm  os.FileMode
+	filePerm os.FileMode
+)
+
+// Extensions uploads must end in, empty allows anything
+var allowedExts []string
+
+// With -require-content-length chunked uploads are refused, so every upload's
+// size is known before any of it is written
+var requireLength bool
+
 func init() {
 	logFlags := log.Ldate | log.Ltime | log.Lshortfile
 
@@ -28,6 +42,8 @@ func init() {
 }
 
 func main() {
+	initConfig()
+
 	mux := http.NewServeMux()
 	mux.Handle("/", routeSplitter())
 
@@ -36,25 +52,75 @@ func main() {
 	s := http.Server{
 		Addr:    port,
 		Handler: wrappedMux,
+		// Clients keep connections open between uploads, don't hold idle
+		// ones forever
+		IdleTimeout: 2 * time.Minute,
+	}
+
+	ln, err := systemdListener()
+	if err != nil {
+		er.Fatal("Error using systemd socket: ", err)
+	}
+	if ln != nil {
+		dbg.Println("Serving on systemd socket at ", ln.Addr())
+		er.Fatal(s.Serve(ln))
 	}
 
 	dbg.Println("Serving at ", port)
 	er.Fatal(s.ListenAndServe())
 }
 
-// POSTs to memory-optimized file sink
-// GETs through standard Golang fileserver (gosh that's nice)
+// With -read-only the sink only serves, uploads are refused
+var readOnly bool
+
+// Methods routeSplitter does something with, advertised via Allow
+func allowedMethods() string {
+	if readOnly {
+		return "GET, HEAD, OPTIONS"
+	}
+	if allowDelete {
+		return "GET, HEAD, POST, PUT, MKCOL, DELETE, OPTIONS"
+	}
+	return "GET, HEAD, POST, PUT, MKCOL, OPTIONS"
+}
+
+// POSTs and PUTs to memory-optimized file sink, unless read-only
+// MKCOLs create directories, also unless read-only
+// DELETEs remove files or trees, only with -allow-delete
+// GET /stats reports on what's stored, GET /manifest lists it
+// GETs and HEADs through standard Golang fileserver (gosh that's nice)
+// OPTIONS answers with what's allowed
 // Drop all else
 func routeSplitter() http.Handler {
 	raspi := raspiZipHandler{}
-	fileserver := http.FileServer(http.Dir("."))
+	root := http.Dir(".")
+	fileserver := http.FileServer(root)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
+		if (r.Method == "POST" || r.Method == "PUT") && !readOnly {
 			raspi.ServeHTTP(w, r)
-		} else if r.Method == "GET" {
+		} else if r.Method == "MKCOL" && !readOnly {
+			makeDirectory(w, r)
+		} else if r.Method == "DELETE" && allowDelete && !readOnly {
+			deletePath(w, r)
+		} else if r.Method == "GET" && r.URL.Path == "/stats" {
+			stats.ServeHTTP(w, r)
+		} else if r.Method == "GET" && r.URL.Path == "/manifest" {
+			storedManifest.ServeHTTP(w, r)
+		} else if r.Method == "GET" || r.Method == "HEAD" {
+			if indexJSON && r.Method == "GET" && wantsJSON(r) && serveJSONIndex(w, r, root) {
+				return
+			}
+			if indexTmpl != nil && r.Method == "GET" && serveIndex(w, r, root) {
+				return
+			}
+			setSidecarDigest(w, root, r.URL.Path)
 			fileserver.ServeHTTP(w, r)
+		} else if r.Method == "OPTIONS" {
+			w.Header().Set("Allow", allowedMethods())
+			w.WriteHeader(204)
 		} else {
+			w.Header().Set("Allow", allowedMethods())
 			w.WriteHeader(405)
 		}
 	})
@@ -67,26 +133,109 @@ type raspiZipHandler struct{}
 func (r raspiZipHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	name := strings.TrimLeft(req.URL.Path, "/\\")
 
-	err := os.MkdirAll(filepath.Dir(name), createPerm)
+	if requireLength && req.ContentLength < 0 {
+		er.Println("Rejecting upload without a Content-Length: ", name)
+		w.WriteHeader(http.StatusLengthRequired)
+		return
+	}
+
+	// Neither is stored at name itself, each entry is locked as it's stored
+	if req.Header.Get(batchHeader) == "tar" {
+		extractBatch(w, req, name)
+		return
+	}
+	if extractArchives && isArchive(name) {
+		extractArchive(w, req, name)
+		return
+	}
+
+	unlock, ok := uploadLocks.acquire(name, !rejectConcurrent)
+	if !ok {
+		er.Println("Rejecting upload already in progress for: ", name)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	if !extensionAllowed(name) {
+		er.Println("Rejecting upload with disallowed extension: ", name)
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	err := os.MkdirAll(filepath.Dir(name), dirPerm)
 	if err != nil {
 		logServError(w, "Error creating wrapping directories", err)
 		return
 	}
 
-	out, err := os.Create(name)
-	if err != nil {
-		logServError(w, "Error creating outfile", err)
+	name, err = resolveConflict(name)
+	if err == errConflict {
+		er.Println("Rejecting upload for existing file: ", name)
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		logServError(w, "Error claiming upload path", err)
 		return
 	}
+	w.Header().Set(storedAsHeader, "/"+filepath.ToSlash(name))
+
+	// Until the upload is in place, anything going wrong removes the empty
+	// file rename or reject claimed the path with, so it isn't left looking
+	// like a finished upload
+	placed := false
+	defer func() {
+		if !placed && onConflict != "overwrite" {
+			os.Remove(name)
+		}
+	}()
 
 	// buffer for copy - standard copy uses awful 32KB buffer
 	buf := make([]byte, copyBufferSize)
-	_, err = io.CopyBuffer(out, req.Body, buf)
+	staged, err := stageUpload(filepath.Dir(name), req.Body, buf)
 	if err != nil {
+		// Most likely the client went away part way
 		logServError(w, "Error while copying file data", err)
 		return
 	}
-	out.Close()
+
+	if !digestMatches(req, staged.hash) {
+		staged.discard()
+		er.Println("Checksum mismatch, discarded upload: ", name)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte("Checksum mismatch"))
+		return
+	}
+
+	err = staged.place(name)
+	if err != nil {
+		logServError(w, "Error moving upload into place", err)
+		return
+	}
+	placed = true
+	err = staged.finish(name, req)
+	if err != nil {
+		logServError(w, "Error writing upload sidecars", err)
+		return
+	}
+
+	audit.record(w, req, name, staged.size, staged.hash)
+	storedManifest.invalidate()
+	runOnComplete(name)
+}
+
+func extensionAllowed(name string) bool {
+	if len(allowedExts) == 0 {
+		return true
+	}
+
+	lower := strings.ToLower(name)
+	for _, ext := range allowedExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
 }
 
 // Below struct wraps server mux to provide logging on all requests
@@ -106,9 +255,15 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 
 func serveLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
 		lrw := newLoggingResponseWriter(w)
 		next.ServeHTTP(lrw, r)
-		dbg.Printf("%s %d %s", r.Method, lrw.statusCode, r.URL)
+		dbg.Printf("%s %d %s [%s]", r.Method, lrw.statusCode, r.URL, id)
 	})
 }
 
@@ -118,3 +273,96 @@ func logServError(w http.ResponseWriter, msg string, err error) {
 	w.WriteHeader(500)
 	w.Write([]byte(msg))
 }
+
+func initConfig() {
+	dirModePtr := flag.String("dir-mode", "0755", "Octal permissions for directories created by the server")
+	fileModePtr := flag.String("file-mode", "0644", "Octal permissions for files created by the server")
+	extsPtr := flag.String("allowed-extensions", "", "Comma separated extensions uploads must have, e.g. .zip,.img,.tar.gz")
+	algoPtr := flag.String("checksum-algo", "sha256", "Digest algorithm to verify uploads with: sha256, sha1 or md5")
+	flag.StringVar(&onComplete, "on-complete", "", "Command to run after each upload, e.g. \"unzip -o {{path}} -d {{dir}}\"; also {{name}}")
+	flag.BoolVar(&onCompleteShell, "on-complete-shell", false, "Run -on-complete through sh -c, with substituted values quoted")
+	indexPtr := flag.String("index-template", "", "html/template file to render directory listings with instead of the default")
+	flag.BoolVar(&indexJSON, "index-json", false, "Answer directory GETs with Accept: application/json or ?format=json with a JSON listing")
+	flag.BoolVar(&extractArchives, "extract-archives", false, "Unpack uploads named .tar, .tar.gz or .tgz into their directory instead of storing them")
+	flag.BoolVar(&dedupe, "dedupe", false, "Store each distinct upload once, hardlinking duplicate paths to it")
+	flag.StringVar(&blobDir, "blob-dir", ".blobs", "Where -dedupe keeps content addressed blobs, must be on the same filesystem")
+	flag.StringVar(&tempDir, "tempdir", "", "Write uploads here first, e.g. a tmpfs or SSD, then move them into place once complete")
+	flag.BoolVar(&fsyncUploads, "fsync", false, "Flush each upload to disk before responding, durable across power loss but slower")
+	flag.BoolVar(&writeSidecars, "sidecars", false, "Write a .sha256 sidecar for each upload and advertise it as a Digest header")
+	flag.BoolVar(&strongETags, "etags", false, "Send stored checksums as strong ETags on GETs and HEADs, from sidecars or -checksum-cache")
+	flag.BoolVar(&storeMetadata, "store-metadata", false, "Write a .meta.json sidecar for each upload recording its source, type, size and checksum")
+	flag.BoolVar(&rejectConcurrent, "reject-concurrent", false, "Answer 409 to an upload for a path already being uploaded, rather than waiting for it")
+	cachePtr := flag.String("checksum-cache", "", "File to cache checksums of stored files in, so GETs and HEADs can carry a Digest without sidecars")
+	flag.BoolVar(&requireLength, "require-content-length", false, "Answer 411 to uploads without a Content-Length, e.g. from clients not using -relay-encoding buffer")
+	flag.BoolVar(&allowDelete, "allow-delete", false, "Accept DELETE for files, and whole directories with ?recursive=true")
+	flag.BoolVar(&readOnly, "read-only", false, "Only serve files, refusing uploads with 405")
+	flag.StringVar(&onConflict, "on-conflict", "overwrite", "What to do with uploads for existing files: overwrite, reject with 409, or rename with a numeric suffix")
+	auditPtr := flag.String("audit-log", "", "File to append a JSON line to for every stored file, synced as each is written")
+	logFilePtr := flag.String("log-file", "", "File to append logs to instead of stdout/stderr, reopened on SIGHUP")
+	flag.Parse()
+
+	if *logFilePtr != "" {
+		useLogFile(*logFilePtr)
+		keepOutOfManifest(*logFilePtr)
+	}
+
+	if *auditPtr != "" {
+		keepOutOfManifest(*auditPtr)
+		var err error
+		audit, err = openAuditLog(*auditPtr)
+		if err != nil {
+			er.Fatal("Error opening audit log: ", err)
+		}
+	}
+
+	if onComplete != "" && len(strings.Fields(onComplete)) == 0 {
+		er.Fatal("-on-complete needs a command")
+	}
+
+	if *indexPtr != "" {
+		var err error
+		indexTmpl, err = loadIndexTemplate(*indexPtr)
+		if err != nil {
+			er.Fatal("Error loading index template: ", err)
+		}
+	}
+
+	if *cachePtr != "" {
+		keepOutOfManifest(*cachePtr)
+		var err error
+		sumCache, err = loadChecksumCache(*cachePtr)
+		if err != nil {
+			er.Fatal("Error loading checksum cache: ", err)
+		}
+	}
+
+	algo, ok := checksumAlgos[*algoPtr]
+	if !ok {
+		er.Fatal("Unknown checksum algorithm: ", *algoPtr)
+	}
+	checksum = algo
+
+	switch onConflict {
+	case "overwrite", "reject", "rename":
+	default:
+		er.Fatal("Unknown -on-conflict policy: ", onConflict)
+	}
+
+	dirPerm = parseMode(*dirModePtr)
+	filePerm = parseMode(*fileModePtr)
+
+	for _, ext := range strings.Split(*extsPtr, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" {
+			allowedExts = append(allowedExts, ext)
+		}
+	}
+}
+
+func parseMode(mode string) os.FileMode {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > uint64(os.ModePerm) {
+		er.Fatal("Not a valid octal permission: ", mode)
+	}
+	return os.FileMode(m)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUploadCutShortLeavesNothing(t *testing.T) {
+	dirPerm, filePerm = 0755, 0644
+	tests := []struct {
+		name       string
+		tempDir    bool
+		onConflict string
+	}{
+		{"in place", false, "overwrite"},
+		{"with -tempdir", true, "overwrite"},
+		{"with -on-conflict reject", false, "reject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			chdir(t, root)
+			if tt.tempDir {
+				tempDir = t.TempDir()
+				defer func() { tempDir = "" }()
+			}
+			onConflict = tt.onConflict
+			defer func() { onConflict = "overwrite" }()
+
+			handled := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(handled)
+				raspiZipHandler{}.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+
+			// Promise a megabyte, send a little, then hang up
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(conn, "PUT /dir/file.bin HTTP/1.1\r\nHost: sink\r\nContent-Length: %d\r\n\r\n", 1<<20)
+			conn.Write(make([]byte, 1000))
+			conn.Close()
+
+			select {
+			case <-handled:
+			case <-time.After(5 * time.Second):
+				t.Fatal("upload never finished after the client went away")
+			}
+			for _, dir := range []string{root, tempDir} {
+				if dir != "" {
+					assertNoFiles(t, dir)
+				}
+			}
+		})
+	}
+}
+
+func chdir(t *testing.T, dir string) {
+	prev, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(prev) })
+}
+
+func assertNoFiles(t *testing.T, dir string) {
+	t.Helper()
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("left behind %s (%d bytes)", path, info.Size())
+		}
+		return nil
+	})
+}
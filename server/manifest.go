@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Below backs GET /manifest, everything stored in one response so clients
+// needn't HEAD each file. It's the same JSON as a client -manifest, keyed by
+// unescaped path relative to the serving directory, so it can be given to the
+// client's -diff. Only what uploads stored is listed, not the server's own
+// temp files, blobs and logs.
+type sinkManifest struct {
+	mu sync.Mutex
+	// Bumped by every upload, data is only current while built matches it
+	gen   int
+	built int
+	data  []byte
+}
+
+type manifestFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// As algo:hex, from a sidecar or -checksum-cache when there is one
+	Checksum string `json:"checksum,omitempty"`
+}
+
+var storedManifest = sinkManifest{built: -1}
+
+// Called whenever an upload lands, so the next request walks the tree again
+func (m *sinkManifest) invalidate() {
+	m.mu.Lock()
+	m.gen++
+	m.mu.Unlock()
+}
+
+func (m *sinkManifest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	gen, data := m.gen, m.data
+	current := m.built == gen
+	m.mu.Unlock()
+
+	// Walked without the lock, so uploads invalidating it meanwhile aren't
+	// held up. Only cached if none landed, else the next request walks again.
+	if !current {
+		var err error
+		data, err = json.Marshal(walkManifest())
+		if err != nil {
+			logServError(w, "Error building manifest", err)
+			return
+		}
+		m.mu.Lock()
+		if m.gen == gen {
+			m.data, m.built = data, gen
+		}
+		m.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func walkManifest() map[string]manifestFile {
+	files := map[string]manifestFile{}
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && path != "." && (path == treePath(blobDir) || path == treePath(tempDir)) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || isSidecar(path) || auxFiles[path] {
+			return nil
+		}
+		// An upload still coming in, see createUploadTemp
+		if strings.HasPrefix(info.Name(), ".upload-") {
+			return nil
+		}
+
+		name := filepath.ToSlash(path)
+		entry := manifestFile{Size: info.Size(), ModTime: info.ModTime()}
+		sum := sidecarChecksum(".", name)
+		if sum == "" && sumCache != nil {
+			sum = sumCache.sum(".", name)
+		}
+		if sum != "" {
+			entry.Checksum = "sha256:" + sum
+		}
+		files[name] = entry
+		return nil
+	})
+	return files
+}
+
+// Files the server writes into the tree itself, like -audit-log, by their
+// path relative to it
+var auxFiles = map[string]bool{}
+
+func keepOutOfManifest(path string) {
+	auxFiles[treePath(path)] = true
+}
+
+// A path as the walk of the serving directory would come across it, whether
+// it was given relative or absolute
+func treePath(path string) string {
+	if path == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return rel
+}
+
+func isSidecar(name string) bool {
+	return strings.HasSuffix(name, checksumSidecarExt) || strings.HasSuffix(name, metadataSidecarExt)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWalkManifestListsOnlyStoredFiles(t *testing.T) {
+	chdir(t, t.TempDir())
+	for _, name := range []string{
+		"a b.txt",
+		"sub/c.txt",
+		"sub/.upload-123",
+		".blobs/0123abcd",
+		"audit.jsonl",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aux, blobs := auxFiles, blobDir
+	auxFiles, blobDir = map[string]bool{}, ".blobs"
+	t.Cleanup(func() { auxFiles, blobDir = aux, blobs })
+	keepOutOfManifest("audit.jsonl")
+
+	var got []string
+	for name := range walkManifest() {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if want := []string{"a b.txt", "sub/c.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest lists %q, want %q", got, want)
+	}
+}
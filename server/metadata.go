@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Stored alongside a file with -store-metadata, recording where it came from
+const metadataSidecarExt = ".meta.json"
+
+// Set by clients relaying a file, naming its source URL
+const sourceHeader = "X-Fetch2pi-Source"
+
+var storeMetadata bool
+
+type uploadMetadata struct {
+	SourceURL    string    `json:"sourceUrl,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	ModTime      time.Time `json:"modTime"`
+	Size         int64     `json:"size"`
+	ChecksumAlgo string    `json:"checksumAlgo"`
+	Checksum     string    `json:"checksum"`
+	UploadedAt   time.Time `json:"uploadedAt"`
+}
+
+// Record provenance for a finished upload in <name>.meta.json
+func writeMetadata(name string, req *http.Request, h hash.Hash) error {
+	if !storeMetadata {
+		return nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	meta := uploadMetadata{
+		SourceURL:    req.Header.Get(sourceHeader),
+		ContentType:  req.Header.Get("Content-Type"),
+		ModTime:      info.ModTime(),
+		Size:         info.Size(),
+		ChecksumAlgo: checksum.token,
+		Checksum:     hex.EncodeToString(h.Sum(nil)),
+		UploadedAt:   time.Now(),
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name+metadataSidecarExt, data, filePerm)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MKCOL, as in WebDAV, creates a directory. Clients use it to carry over
+// directories that are empty at the source, which uploads alone never would.
+func makeDirectory(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimLeft(req.URL.Path, "/\\")
+	if name == "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := os.MkdirAll(name, dirPerm)
+	if err != nil {
+		logServError(w, "Error creating directory", err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// Below serializes uploads to the same path, so concurrent writers can't
+// interleave or clobber each other. With -reject-concurrent the second gets
+// a 409 rather than waiting its turn.
+type pathLocks struct {
+	mu   sync.Mutex
+	held map[string]*pathLock
+}
+
+// A one slot channel works as a mutex that can be tried without blocking
+type pathLock struct {
+	slot chan struct{}
+	refs int
+}
+
+var uploadLocks = pathLocks{held: map[string]*pathLock{}}
+
+var rejectConcurrent bool
+
+// Take the lock for name, returning the func to release it. Unless wait is
+// set, gives up straight away if it's held and returns false.
+func (p *pathLocks) acquire(name string, wait bool) (func(), bool) {
+	key := filepath.Clean(name)
+
+	p.mu.Lock()
+	l, ok := p.held[key]
+	if !ok {
+		l = &pathLock{slot: make(chan struct{}, 1)}
+		p.held[key] = l
+	}
+	l.refs++
+	p.mu.Unlock()
+
+	if wait {
+		l.slot <- struct{}{}
+	} else {
+		select {
+		case l.slot <- struct{}{}:
+		default:
+			p.release(key, l)
+			return nil, false
+		}
+	}
+
+	return func() {
+		<-l.slot
+		p.release(key, l)
+	}, true
+}
+
+// Drop a reference, forgetting the lock once nobody holds or wants it
+func (p *pathLocks) release(key string, l *pathLock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(p.held, key)
+	}
+}
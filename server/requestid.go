@@ -0,0 +1,16 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Clients may send one to tie their logs to ours, otherwise we make one up.
+// Either way it's echoed back and logged with the request.
+const requestIDHeader = "X-Request-ID"
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
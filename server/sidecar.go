@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+)
+
+// With -sidecars every upload gets a SHA-256 sidecar, which is then advertised
+// as a Digest header on GETs and HEADs so clients can tell what we hold
+var writeSidecars bool
+
+// With -etags the same checksum is sent as a strong ETag, which the
+// fileserver then answers If-None-Match against with a 304
+var strongETags bool
+
+// Hash to feed an upload through for its sidecar, nil when they're off
+func newSidecarHash() hash.Hash {
+	if !writeSidecars {
+		return nil
+	}
+	return sha256.New()
+}
+
+// Record a stored file's checksum next to it, in sha256sum's output format
+func writeSidecar(name string, h hash.Hash) error {
+	if h == nil {
+		return nil
+	}
+	line := hex.EncodeToString(h.Sum(nil)) + "  " + filepath.Base(name) + "\n"
+	return ioutil.WriteFile(name+checksumSidecarExt, []byte(line), filePerm)
+}
+
+// Advertise a file's sidecar checksum, if it has one, as an RFC 3230 Digest.
+// Otherwise with -checksum-cache it's hashed, or looked up if unchanged.
+// Also used as the ETag with -etags.
+func setSidecarDigest(w http.ResponseWriter, root http.Dir, name string) {
+	stored := sidecarChecksum(string(root), name)
+	if stored == "" && sumCache != nil {
+		stored = sumCache.sum(string(root), name)
+	}
+	sum, err := hex.DecodeString(stored)
+	if err != nil || len(sum) != sha256.Size {
+		return
+	}
+	w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum))
+	if strongETags {
+		w.Header().Set("ETag", `"`+stored+`"`)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long a walk of the storage tree is reused for before being redone
+const storageCacheTime = 30 * time.Second
+
+// Below backs GET /stats, a one request view of the sink's state
+type sinkStats struct {
+	received int64
+	uploads  int64
+
+	mu       sync.Mutex
+	walkedAt time.Time
+	files    int64
+	bytes    int64
+}
+
+var stats sinkStats
+
+type statsResponse struct {
+	Files         int64   `json:"files"`
+	Bytes         int64   `json:"bytes"`
+	FreePercent   float64 `json:"freePercent"`
+	Uploads       int64   `json:"uploadsSinceStart"`
+	BytesReceived int64   `json:"bytesReceivedSinceStart"`
+}
+
+func (s *sinkStats) recordUpload(n int64) {
+	atomic.AddInt64(&s.received, n)
+	atomic.AddInt64(&s.uploads, 1)
+}
+
+// Totals for everything stored, walking the tree at most every storageCacheTime
+func (s *sinkStats) storage() (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.walkedAt) < storageCacheTime {
+		return s.files, s.bytes
+	}
+
+	var files, bytes int64
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && dedupe && path == filepath.Clean(blobDir) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+
+	s.files, s.bytes, s.walkedAt = files, bytes, time.Now()
+	return files, bytes
+}
+
+func (s *sinkStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	files, bytes := s.storage()
+	resp := statsResponse{
+		Files:         files,
+		Bytes:         bytes,
+		Uploads:       atomic.LoadInt64(&s.uploads),
+		BytesReceived: atomic.LoadInt64(&s.received),
+	}
+	free, err := freePercent(".")
+	if err != nil {
+		er.Println("Error checking free disk space: ", err)
+	}
+	resp.FreePercent = free
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+// The first descriptor systemd passes, after stdin, stdout and stderr
+const listenFdsStart = 3
+
+// When started by a systemd socket unit the listening socket is handed over
+// as fd 3, described by LISTEN_PID and LISTEN_FDS. Returns nil when we weren't
+// socket activated and should bind the port ourselves.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	// Don't pass them on to anything -on-complete runs
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// With -tempdir, uploads are written somewhere faster than the storage root,
+// a tmpfs or SSD say, and only moved into place once they're complete
+var tempDir string
+
+func createUploadTemp(dir string) (*os.File, error) {
+	err := os.MkdirAll(dir, dirPerm)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, ".upload-")
+}
+
+// Rename src to dst, or when they're on different filesystems copy it to a
+// temp file beside dst first, so dst still only ever appears complete
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createUploadTemp(filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, copyBufferSize)
+	_, err = io.CopyBuffer(out, in, buf)
+	if err == nil {
+		err = os.Chmod(out.Name(), filePerm)
+	}
+	if err == nil && fsyncUploads {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(src)
+}
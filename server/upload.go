@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Below is how every upload is written, single files and archive entries
+// alike: to a temp file first, hashed as it streams in, and only put in place
+// at its path once it's complete and checked
+type stagedUpload struct {
+	tmp  string
+	size int64
+	// With -checksum-algo, for the -sidecars file (nil without), and the
+	// SHA-256 -dedupe names blobs by (nil without)
+	hash hash.Hash
+	side hash.Hash
+	blob hash.Hash
+}
+
+// Write r out to a temp file in the blob store with -dedupe, -tempdir if set,
+// or otherwise dir, which should be on the same filesystem as where it ends up
+func stageUpload(dir string, r io.Reader, buf []byte) (*stagedUpload, error) {
+	var out *os.File
+	var err error
+	if dedupe {
+		out, err = createBlobTemp()
+	} else if tempDir != "" {
+		out, err = createUploadTemp(tempDir)
+	} else {
+		out, err = createUploadTemp(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stagedUpload{tmp: out.Name(), hash: checksum.new(), side: newSidecarHash()}
+	if dedupe {
+		s.blob = sha256.New()
+	}
+	// Temp files are made 0600, so set the configured mode
+	err = os.Chmod(s.tmp, filePerm)
+	if err == nil {
+		dst := io.MultiWriter(out, s.hash)
+		if s.side != nil {
+			dst = io.MultiWriter(dst, s.side)
+		}
+		if s.blob != nil {
+			dst = io.MultiWriter(dst, s.blob)
+		}
+		s.size, err = io.CopyBuffer(dst, r, buf)
+		stats.recordUpload(s.size)
+	}
+	if err == nil && fsyncUploads {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(s.tmp)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *stagedUpload) discard() {
+	os.Remove(s.tmp)
+}
+
+// Move the upload into place at name, or with -dedupe link it to its blob
+func (s *stagedUpload) place(name string) error {
+	if dedupe {
+		return linkBlob(s.tmp, s.blob, name)
+	}
+	err := moveFile(s.tmp, name)
+	if err != nil {
+		os.Remove(s.tmp)
+	}
+	return err
+}
+
+// Write what's kept beside a placed upload, and flush it all with -fsync
+func (s *stagedUpload) finish(name string, req *http.Request) error {
+	err := writeSidecar(name, s.side)
+	if err == nil {
+		err = writeMetadata(name, req, s.hash)
+	}
+	if err == nil && fsyncUploads {
+		err = syncPlacement(name)
+	}
+	return err
+}
+
+// Put an entry of a batch or archive in place like a single upload to name
+// would be, under its path's lock and -on-conflict. Returns where it was
+// stored, or errConflict if it was turned away like a single upload would
+// get a 409.
+func storeEntry(name string, s *stagedUpload, req *http.Request) (string, error) {
+	unlock, ok := uploadLocks.acquire(name, !rejectConcurrent)
+	if !ok {
+		s.discard()
+		return "", errConflict
+	}
+	defer unlock()
+
+	err := os.MkdirAll(filepath.Dir(name), dirPerm)
+	if err != nil {
+		s.discard()
+		return "", err
+	}
+	stored, err := resolveConflict(name)
+	if err != nil {
+		s.discard()
+		return "", err
+	}
+	err = s.place(stored)
+	if err != nil {
+		if onConflict != "overwrite" {
+			os.Remove(stored)
+		}
+		return "", err
+	}
+	return stored, s.finish(stored, req)
+}